@@ -7,6 +7,7 @@ import (
 	"os"
 
 	"github.com/lixiaofei123/lhbin/config"
+	"github.com/lixiaofei123/lhbin/driver"
 )
 
 const AccountCommandName string = "account"
@@ -17,6 +18,7 @@ func init() {
 	RegisterChildCommandOperator(AccountCommandName, "add", "添加新的账户", []string{}, SafeOperation(AddAccount))
 	RegisterChildCommandOperator(AccountCommandName, "del", "删除指定账户", []string{"delete"}, SafeOperation(DeleteAccount))
 	RegisterChildCommandOperator(AccountCommandName, "list", "列出所有账户", []string{}, SafeOperation(ListAccounts))
+	RegisterChildCommandOperator(AccountCommandName, "verify", "校验账户密钥是否有效并检查轻量服务器的接口权限", []string{"check"}, SafeOperation(VerifyAccount))
 }
 
 func AddAccount() error {
@@ -25,11 +27,13 @@ func AddAccount() error {
 	var account string // 账号
 	var akid string
 	var aksecret string
+	var skipVerify bool
 
 	flag.StringVar(&driverName, "driver", "qqcloud", "云厂商类型，目前仅支持腾讯云")
 	flag.StringVar(&account, "account", "", "账号名称，区分多用户使用，可随意指定")
 	flag.StringVar(&akid, "id", "", "密钥ID")
 	flag.StringVar(&aksecret, "key", "", "密钥key")
+	flag.BoolVar(&skipVerify, "skip-verify", false, "跳过密钥校验，直接保存")
 
 	flag.CommandLine.Parse(os.Args[3:])
 
@@ -41,12 +45,26 @@ func AddAccount() error {
 	checkArg(&akid, "密钥ID不能为空")
 	checkArg(&aksecret, "密钥Key不能为空")
 
-	config.AddAccount(&config.AccountConfig{
+	newAccount := &config.AccountConfig{
 		Driver:   config.DriverName(driverName),
 		Account:  account,
 		AKID:     akid,
 		AKSecret: aksecret,
-	})
+	}
+
+	if !skipVerify {
+		cdriver, err := driver.GetDriver(newAccount)
+		if err != nil {
+			return err
+		}
+		if err := verifyAccount(cdriver, ""); err != nil {
+			return fmt.Errorf("密钥校验失败，账户未保存(可以加上--skip-verify参数跳过校验)，原因是:%s", err.Error())
+		}
+	}
+
+	if err := config.AddAccount(newAccount); err != nil {
+		return err
+	}
 
 	fmt.Printf("配置账户%s成功", account)
 	return nil
@@ -63,7 +81,9 @@ func DeleteAccount() error {
 	}
 
 	checkArg(&account, "账号名称不能为空")
-	config.DeleteAccount(config.DriverName(driverName), account)
+	if err := config.DeleteAccount(config.DriverName(driverName), account); err != nil {
+		return err
+	}
 	fmt.Printf("删除账户%s成功\n", account)
 
 	return nil
@@ -82,3 +102,66 @@ func ListAccounts() error {
 
 	return nil
 }
+
+func VerifyAccount() error {
+
+	var region string
+
+	cdrivers, err := parseAndGetDrivers(func() {
+		flag.StringVar(&region, "region", "", "用于检查接口权限的地域，不填则为账户的默认地域，未设置默认地域则为ap-guangzhou")
+	}, func() error { return nil }, os.Args[3:])
+
+	if err != nil {
+		return err
+	}
+
+	for _, cdriver := range cdrivers {
+		fmt.Printf("账户%s:\n", cdriver.account)
+		if err := verifyAccount(cdriver.driver, cdriver.region); err != nil {
+			fmt.Printf("账户%s校验失败，原因是:%s \n", cdriver.account, err.Error())
+		}
+		fmt.Println()
+	}
+
+	return nil
+}
+
+// verifyAccount 校验密钥是否有效，打印账户的UIN以及轻量服务器只读接口的权限
+func verifyAccount(cdriver driver.Driver, region string) error {
+
+	if region == "" {
+		region = "ap-guangzhou"
+	}
+
+	permissions, err := cdriver.CheckPermissions(region)
+	if err != nil {
+		return err
+	}
+
+	identity, err := cdriver.AccountIdentity()
+	if err != nil {
+		fmt.Printf("密钥有效，但是查询账户身份信息失败，原因是:%s \n", err.Error())
+	} else {
+		fmt.Println("-------------------------------")
+		fmt.Println("| 主账号UIN | ", identity.AccountId, "|")
+		fmt.Println("| 调用者UIN | ", identity.UserId, "|")
+		fmt.Println("| 身份类型 | ", identity.Type, "|")
+		fmt.Println("| ARN | ", identity.Arn, "|")
+		fmt.Println("-------------------------------")
+	}
+
+	fmt.Println("------------------------------------------")
+	fmt.Println("| 接口 | 是否有权限 |")
+	fmt.Println("------------------------------------------")
+	for _, permission := range permissions {
+		allowed := "是"
+		if !permission.Allowed {
+			allowed = "否"
+		}
+		fmt.Println("|", permission.Action, "|", allowed, "|")
+		fmt.Println("------------------------------------------")
+	}
+	fmt.Println("只检查了只读接口的权限，写操作的权限无法在不修改资源的情况下进行检查")
+
+	return nil
+}
@@ -18,6 +18,7 @@ func init() {
 	RegisterChildCommandOperator(BlueprintCommandName, "desc", "查看符合要求的镜像详情", []string{"describe"}, SafeOperation(DescribeBlueprint))
 	RegisterChildCommandOperator(BlueprintCommandName, "del", "删除符合要求的镜像", []string{"delete"}, DangerOperation("镜像删除后不能恢复", DeleteBlueprints))
 	RegisterChildCommandOperator(BlueprintCommandName, "create", "创建镜像", []string{}, SafeOperation(CreateBlueprint))
+	RegisterChildCommandOperator(BlueprintCommandName, "usage", "查看使用此镜像的实例", []string{}, SafeOperation(BlueprintUsage))
 
 }
 
@@ -110,7 +111,25 @@ func DescribeBlueprint() error {
 	fmt.Println("-------------------------------")
 	fmt.Println("| 镜像名称 | ", blueprint.Name, "|")
 	fmt.Println("| 镜像ID | ", blueprint.Blueprint, "|")
+	fmt.Println("| 镜像类型 | ", blueprint.Type, "|")
 	fmt.Println("| 操作系统 | ", blueprint.OsName, "|")
+	fmt.Println("| 系统平台 | ", blueprint.Platform, "|")
+	fmt.Println("| 平台类型 | ", blueprint.PlatformType, "|")
+	if blueprint.IsWindows() {
+		fmt.Println("| 登录方式 | ", "远程桌面，只支持密码登录", "|")
+	} else {
+		fmt.Println("| 登录方式 | ", "SSH，支持密码和密钥对登录", "|")
+	}
+	fmt.Println("| 支持自动化助手 | ", blueprint.SupportTat, "|")
+	if blueprint.ImageId != "" {
+		fmt.Println("| CVM镜像ID | ", blueprint.ImageId, "|")
+	}
+	if blueprint.ImageSize > 0 {
+		fmt.Println("| 镜像大小 | ", blueprint.ImageSize, "GB |")
+	}
+	if !blueprint.CreatedTime.IsZero() {
+		fmt.Println("| 创建时间 | ", blueprint.CreatedTime.Local().Format("2006-01-02 15:04:05"), "|")
+	}
 	fmt.Println("| 最小磁盘要求 | ", blueprint.RequiredDiskSize, "GB |")
 	fmt.Println("| 最小内存要求 | ", blueprint.RequiredMemory, "GB |")
 	fmt.Println("| 状态 | ", blueprint.State, "|")
@@ -148,6 +167,9 @@ func DeleteBlueprints() error {
 	bpids := strings.Split(blurprintIDs, ",")
 
 	for _, bpid := range bpids {
+		if planned("将删除%s地域下的镜像%s", region, bpid) {
+			continue
+		}
 		err := cdriver.DeleteBlueprints(region, []string{bpid})
 		if err != nil {
 			fmt.Printf("%s地域下的镜像%s删除失败，原因是:%s \n", region, bpid, err.Error())
@@ -176,3 +198,42 @@ func CreateBlueprint() error {
 	})
 
 }
+
+func BlueprintUsage() error {
+
+	var region string
+	var blurprintID string
+
+	cdriver, err := parseAndGetDriver(func() {
+		flag.StringVar(&region, "region", "", "地域")
+		flag.StringVar(&blurprintID, "imageid", "", "镜像ID")
+	}, func() error {
+		checkArg(&region, "地域不能为空")
+		checkArg(&blurprintID, "镜像ID不能为空")
+		return nil
+	}, os.Args[3:])
+
+	if err != nil {
+		return err
+	}
+
+	instances, err := cdriver.BlueprintUsage(region, blurprintID)
+	if err != nil {
+		return err
+	}
+
+	if len(instances) == 0 {
+		fmt.Printf("%s地域下没有实例使用镜像%s\n", region, blurprintID)
+		return nil
+	}
+
+	fmt.Println("------------------------------------------")
+	fmt.Println("| 实例名称 | 实例ID | 公网IP | 状态 |")
+	fmt.Println("------------------------------------------")
+	for _, instance := range instances {
+		fmt.Println("|", instance.Name, "|", instance.ID, "|", instance.PublicIP, "|", instance.State, "|")
+		fmt.Println("------------------------------------------")
+	}
+
+	return nil
+}
@@ -0,0 +1,170 @@
+package cmd
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/lixiaofei123/lhbin/driver"
+)
+
+func init() {
+	RegisterChildCommandOperator(BlueprintCommandName, "edit", "修改私有镜像的名称和描述", []string{"modify"}, SafeOperation(EditBlueprint))
+	RegisterChildCommandOperator(BlueprintCommandName, "prune", "删除较旧的、没有被使用和共享的私有镜像", []string{}, SafeOperation(PruneBlueprints))
+}
+
+func EditBlueprint() error {
+
+	var region string
+	var blurprintID string
+	var name string
+	var desc string
+
+	cdriver, err := parseAndGetDriver(func() {
+		flag.StringVar(&region, "region", "", "地域")
+		flag.StringVar(&blurprintID, "imageid", "", "镜像ID")
+		flag.StringVar(&name, "name", "", "新的镜像名称，为空时不修改")
+		flag.StringVar(&desc, "desc", "", "新的镜像描述，为空时不修改")
+	}, func() error {
+		checkArg(&region, "地域不能为空")
+		checkArg(&blurprintID, "镜像ID不能为空")
+		if name == "" && desc == "" {
+			return fmt.Errorf("镜像名称和描述不能都为空")
+		}
+		return nil
+	}, os.Args[3:])
+
+	if err != nil {
+		return err
+	}
+
+	if planned("将修改%s地域下的镜像%s，名称:%s，描述:%s", region, blurprintID, name, desc) {
+		return nil
+	}
+
+	err = cdriver.ModifyBlueprintAttribute(region, blurprintID, name, desc)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%s地域下的镜像%s修改成功\n", region, blurprintID)
+	return nil
+}
+
+// blueprintInUse 检查镜像是否正在被实例使用或者共享给了其它账户，无法确认时也视为在使用
+func blueprintInUse(cdriver driver.Driver, region string, blueprint *driver.Blueprint) (bool, string) {
+	instances, err := cdriver.BlueprintUsage(region, blueprint.Blueprint)
+	if err != nil {
+		return true, fmt.Sprintf("查询使用情况失败:%s", err.Error())
+	}
+	if len(instances) > 0 {
+		insids := []string{}
+		for _, instance := range instances {
+			insids = append(insids, instance.ID)
+		}
+		return true, fmt.Sprintf("正在被实例%s使用", strings.Join(insids, ","))
+	}
+
+	shares, err := cdriver.BlueprintShares(region, blueprint.Blueprint)
+	if err != nil {
+		return true, fmt.Sprintf("查询共享情况失败:%s", err.Error())
+	}
+	if len(shares) > 0 {
+		accounts := []string{}
+		for _, share := range shares {
+			accounts = append(accounts, share.AccountId)
+		}
+		return true, fmt.Sprintf("已共享给账户%s", strings.Join(accounts, ","))
+	}
+
+	return false, ""
+}
+
+func PruneBlueprints() error {
+
+	var region string
+	var prefix string
+	var keep int
+
+	cdriver, err := parseAndGetDriver(func() {
+		flag.StringVar(&region, "region", "", "地域")
+		flag.StringVar(&prefix, "prefix", "", "只处理名称以此为前缀的私有镜像")
+		flag.IntVar(&keep, "keep", 3, "保留最新的镜像数量")
+	}, func() error {
+		checkArg(&region, "地域不能为空")
+		if keep < 0 {
+			return fmt.Errorf("保留数量不能小于0")
+		}
+		return nil
+	}, os.Args[3:])
+
+	if err != nil {
+		return err
+	}
+
+	bps, err := cdriver.ListBlueprints(region, driver.AllPlatform, driver.PrivateBlueprint)
+	if err != nil {
+		return err
+	}
+
+	candidates := []*driver.Blueprint{}
+	for _, bp := range bps {
+		if strings.HasPrefix(bp.Name, prefix) {
+			candidates = append(candidates, bp)
+		}
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].CreatedTime.After(candidates[j].CreatedTime)
+	})
+
+	if len(candidates) <= keep {
+		fmt.Printf("%s地域下符合条件的私有镜像有%d个，不需要清理\n", region, len(candidates))
+		return nil
+	}
+
+	// 先检查所有候选镜像并列出清理计划，确认后再删除
+	prune := []*driver.Blueprint{}
+	for _, bp := range candidates[keep:] {
+		if bp.State != driver.BlueprintNormal {
+			fmt.Printf("跳过镜像%s(%s)，当前状态为%s\n", bp.Blueprint, bp.Name, bp.State)
+			continue
+		}
+
+		if inUse, reason := blueprintInUse(cdriver, region, bp); inUse {
+			fmt.Printf("跳过镜像%s(%s)，%s\n", bp.Blueprint, bp.Name, reason)
+			continue
+		}
+
+		if !planned("将删除%s地域下的镜像%s(%s)", region, bp.Blueprint, bp.Name) {
+			fmt.Printf("将删除%s地域下的镜像%s(%s)，创建时间%s\n", region, bp.Blueprint, bp.Name, bp.CreatedTime.Format("2006-01-02 15:04:05"))
+		}
+		prune = append(prune, bp)
+	}
+
+	if len(prune) == 0 {
+		fmt.Printf("%s地域下没有可以清理的私有镜像\n", region)
+		return nil
+	}
+	if dryRun || !confirmDanger(fmt.Sprintf("将删除以上%d个镜像，镜像删除后不能恢复", len(prune))) {
+		return nil
+	}
+
+	failed := 0
+	for _, bp := range prune {
+		err := cdriver.DeleteBlueprints(region, []string{bp.Blueprint})
+		if err != nil {
+			failed++
+			fmt.Printf("%s地域下的镜像%s(%s)删除失败，原因是:%s\n", region, bp.Blueprint, bp.Name, err.Error())
+		} else {
+			fmt.Printf("%s地域下的镜像%s(%s)删除成功\n", region, bp.Blueprint, bp.Name)
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("有%d个镜像删除失败", failed)
+	}
+	return nil
+}
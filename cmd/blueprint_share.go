@@ -0,0 +1,193 @@
+package cmd
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/lixiaofei123/lhbin/driver"
+)
+
+func init() {
+	RegisterChildCommandOperator(BlueprintCommandName, "share", "把私有镜像共享给其它账户", []string{}, RiskOperation("共享后对方账户可以使用此镜像创建或者重置实例", ShareBlueprint))
+	RegisterChildCommandOperator(BlueprintCommandName, "unshare", "取消镜像对其它账户的共享", []string{}, SafeOperation(UnshareBlueprint))
+	RegisterChildCommandOperator(BlueprintCommandName, "shared-with", "查看镜像共享给了哪些账户", []string{}, SafeOperation(ListBlueprintShares))
+	RegisterChildCommandOperator(BlueprintCommandName, "copy", "把私有镜像同步到其它地域", []string{"sync"}, SafeOperation(CopyBlueprint))
+}
+
+func parseBlueprintShareArgs() (driver.Driver, string, string, []string, error) {
+
+	var region string
+	var blueprintID string
+	var accounts string
+
+	cdriver, err := parseAndGetDriver(func() {
+		flag.StringVar(&region, "region", "", "镜像所在地域")
+		flag.StringVar(&blueprintID, "imageid", "", "镜像ID")
+		flag.StringVar(&accounts, "to-account", "", "对方账户的UIN，多个用逗号隔开")
+	}, func() error {
+		checkArg(&region, "地域不能为空")
+		checkArg(&blueprintID, "镜像ID不能为空")
+		checkArg(&accounts, "对方账户的UIN不能为空")
+		return nil
+	}, os.Args[3:])
+
+	if err != nil {
+		return nil, "", "", nil, err
+	}
+
+	return cdriver, region, blueprintID, strings.Split(accounts, ","), nil
+}
+
+func ShareBlueprint() error {
+
+	cdriver, region, blueprintID, accountIDs, err := parseBlueprintShareArgs()
+	if err != nil {
+		return err
+	}
+
+	if planned("将把%s地域的镜像%s共享给账户%s", region, blueprintID, strings.Join(accountIDs, ",")) {
+		return nil
+	}
+
+	err = cdriver.ShareBlueprint(region, blueprintID, accountIDs)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%s地域的镜像%s已共享给账户%s\n", region, blueprintID, strings.Join(accountIDs, ","))
+	return nil
+}
+
+func UnshareBlueprint() error {
+
+	cdriver, region, blueprintID, accountIDs, err := parseBlueprintShareArgs()
+	if err != nil {
+		return err
+	}
+
+	if planned("将取消%s地域的镜像%s对账户%s的共享", region, blueprintID, strings.Join(accountIDs, ",")) {
+		return nil
+	}
+
+	err = cdriver.UnshareBlueprint(region, blueprintID, accountIDs)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("已取消%s地域的镜像%s对账户%s的共享\n", region, blueprintID, strings.Join(accountIDs, ","))
+	return nil
+}
+
+func ListBlueprintShares() error {
+
+	var region string
+	var blueprintID string
+
+	cdriver, err := parseAndGetDriver(func() {
+		flag.StringVar(&region, "region", "", "镜像所在地域")
+		flag.StringVar(&blueprintID, "imageid", "", "镜像ID")
+	}, func() error {
+		checkArg(&region, "地域不能为空")
+		checkArg(&blueprintID, "镜像ID不能为空")
+		return nil
+	}, os.Args[3:])
+
+	if err != nil {
+		return err
+	}
+
+	shares, err := cdriver.BlueprintShares(region, blueprintID)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("------------------------------------------")
+	fmt.Println("| 账户UIN | 共享时间 |")
+	fmt.Println("------------------------------------------")
+	for _, share := range shares {
+		fmt.Println("|", share.AccountId, "|", share.CreatedTime, "|")
+		fmt.Println("------------------------------------------")
+	}
+
+	return nil
+}
+
+// waitBlueprintNormal 等待镜像变为NORMAL状态，同步刚开始时目标地域可能还查询不到镜像，此时继续等待
+func waitBlueprintNormal(cdriver driver.Driver, region, blueprintID string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		blueprint, err := cdriver.BlueprintInfo(region, blueprintID)
+		if err == nil && blueprint.State == driver.BlueprintNormal {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			if err != nil {
+				return fmt.Errorf("等待%s地域的镜像%s超时，最后一次查询失败:%s", region, blueprintID, err.Error())
+			}
+			return fmt.Errorf("等待%s地域的镜像%s超时，当前状态为%s", region, blueprintID, blueprint.State)
+		}
+		time.Sleep(10 * time.Second)
+	}
+}
+
+func CopyBlueprint() error {
+
+	var region string
+	var blueprintID string
+	var toRegions string
+	var wait bool
+	var timeout time.Duration
+
+	cdriver, err := parseAndGetDriver(func() {
+		flag.StringVar(&region, "region", "", "镜像所在地域")
+		flag.StringVar(&blueprintID, "imageid", "", "镜像ID")
+		flag.StringVar(&toRegions, "to-region", "", "目标地域，多个用逗号隔开")
+		flag.BoolVar(&wait, "wait", true, "是否等待目标地域的镜像变为NORMAL状态")
+		flag.DurationVar(&timeout, "timeout", 60*time.Minute, "等待的最长时间")
+	}, func() error {
+		checkArg(&region, "地域不能为空")
+		checkArg(&blueprintID, "镜像ID不能为空")
+		checkArg(&toRegions, "目标地域不能为空")
+		return nil
+	}, os.Args[3:])
+
+	if err != nil {
+		return err
+	}
+
+	regions := strings.Split(toRegions, ",")
+
+	if planned("将把%s地域的镜像%s同步到%s", region, blueprintID, toRegions) {
+		return nil
+	}
+
+	err = cdriver.CopyBlueprint(region, blueprintID, regions)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("已开始把%s地域的镜像%s同步到%s\n", region, blueprintID, toRegions)
+
+	if !wait {
+		return nil
+	}
+
+	failed := []string{}
+	for _, toRegion := range regions {
+		err := waitBlueprintNormal(cdriver, toRegion, blueprintID, timeout)
+		if err != nil {
+			fmt.Println(err.Error())
+			failed = append(failed, toRegion)
+		} else {
+			fmt.Printf("%s地域的镜像%s同步完成\n", toRegion, blueprintID)
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("以下地域的镜像同步没有完成:%s", strings.Join(failed, ","))
+	}
+	return nil
+}
@@ -1,14 +1,19 @@
 package cmd
 
 import (
+	"bufio"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"os"
 	"strings"
 
 	"github.com/google/uuid"
 	"github.com/lixiaofei123/lhbin/config"
 	"github.com/lixiaofei123/lhbin/driver"
+	"gopkg.in/yaml.v2"
 )
 
 func checkArg(arg *string, errText string) {
@@ -37,21 +42,218 @@ func parseAndGetDriver(setArgsFunc func(), vaildFunc func() error, arguments []s
 	var driverName string
 	var account string // 账号
 	flag.StringVar(&driverName, "driver", "qqcloud", "云厂商类型，目前仅支持腾讯云，默认为腾讯云")
-	flag.StringVar(&account, "account", "", "账号名称，区分多用户使用，可随意指定。不指定则为默认Driver的第一个账号")
+	flag.StringVar(&account, "account", "", "账号名称，区分多用户使用，可随意指定。不指定则为当前配置的默认账号，未设置默认账号则为默认Driver的第一个账号")
 	flag.CommandLine.Parse(arguments)
 
-	if err := vaildFunc(); err != nil {
+	acc, err := config.FindAcount(config.DriverName(driverName), account)
+	if err != nil {
 		return nil, err
 	}
+	currentAccount = acc.Account
 
-	acc, err := config.FindAcount(config.DriverName(driverName), account)
-	if err != nil {
+	applyDefaultRegion(acc)
+
+	if err := vaildFunc(); err != nil {
 		return nil, err
 	}
 
 	return driver.GetDriver(acc)
 }
 
+// 是否同时操作多个账户，多账户时输出结果中会增加账户列
+var multiAccountMode bool
+
+// parseAndGetDriver实际使用的账户名称，没有指定--account时为默认账户
+var currentAccount string
+
+type accountDriver struct {
+	account string
+	region  string // 该账户下需要操作的地域，为空表示所有地域
+	driver  driver.Driver
+}
+
+// parseAndGetDrivers 和parseAndGetDriver类似，但是支持通过--accounts或者--all-accounts同时选择多个账户
+func parseAndGetDrivers(setArgsFunc func(), vaildFunc func() error, arguments []string) ([]*accountDriver, error) {
+
+	setArgsFunc()
+
+	var driverName string
+	var account string // 账号
+	var accounts string
+	var allAccounts bool
+	flag.StringVar(&driverName, "driver", "qqcloud", "云厂商类型，目前仅支持腾讯云，默认为腾讯云")
+	flag.StringVar(&account, "account", "", "账号名称，区分多用户使用，可随意指定。不指定则为当前配置的默认账号，未设置默认账号则为默认Driver的第一个账号")
+	flag.StringVar(&accounts, "accounts", "", "账号名称列表，用逗号隔开，设置后会同时对多个账户进行操作，并忽略account参数")
+	flag.BoolVar(&allAccounts, "all-accounts", false, "对所有已经配置的账户进行操作，设置后会忽略account以及accounts参数")
+	flag.CommandLine.Parse(arguments)
+
+	accs := []*config.AccountConfig{}
+	if allAccounts {
+		accs = config.FindAccounts(config.DriverName(driverName))
+		if len(accs) == 0 {
+			return nil, errors.New("未找到任何账户")
+		}
+	} else if accounts != "" {
+		for _, account := range strings.Split(accounts, ",") {
+			acc, err := config.FindAcount(config.DriverName(driverName), account)
+			if err != nil {
+				return nil, fmt.Errorf("账户%s不存在", account)
+			}
+			accs = append(accs, acc)
+		}
+	} else {
+		acc, err := config.FindAcount(config.DriverName(driverName), account)
+		if err != nil {
+			return nil, err
+		}
+
+		applyDefaultRegion(acc)
+
+		if err := vaildFunc(); err != nil {
+			return nil, err
+		}
+
+		cdriver, err := driver.GetDriver(acc)
+		if err != nil {
+			return nil, err
+		}
+
+		region := ""
+		if regionFlag := flag.Lookup("region"); regionFlag != nil {
+			region = regionFlag.Value.String()
+		}
+		return []*accountDriver{{account: acc.Account, region: region, driver: cdriver}}, nil
+	}
+
+	multiAccountMode = true
+
+	// 多账户时如果没有指定地域，则每个账户使用各自的默认地域
+	regionSet := false
+	regionFlag := flag.Lookup("region")
+	if regionFlag != nil {
+		flag.Visit(func(f *flag.Flag) {
+			if f.Name == "region" {
+				regionSet = true
+			}
+		})
+		if strings.ToLower(regionFlag.Value.String()) == "all" {
+			regionFlag.Value.Set("")
+		}
+	}
+
+	if err := vaildFunc(); err != nil {
+		return nil, err
+	}
+
+	cdrivers := []*accountDriver{}
+	for _, acc := range accs {
+		cdriver, err := driver.GetDriver(acc)
+		if err != nil {
+			return nil, err
+		}
+
+		region := ""
+		if regionSet {
+			region = regionFlag.Value.String()
+		} else if regionFlag != nil {
+			region = config.DefaultRegion(acc)
+		}
+		if strings.ToLower(region) == "all" {
+			region = ""
+		}
+
+		cdrivers = append(cdrivers, &accountDriver{account: acc.Account, region: region, driver: cdriver})
+	}
+
+	return cdrivers, nil
+}
+
+// accountOf 从批量操作回调的额外参数中取出账户名称
+func accountOf(args []interface{}) string {
+	if len(args) > 0 {
+		if account, ok := args[0].(string); ok {
+			return account
+		}
+	}
+	return ""
+}
+
+// outputOf 从批量操作回调的额外参数中取出输出位置，并发执行时为每个实例单独的缓冲区，否则为标准输出
+func outputOf(args []interface{}) io.Writer {
+	if len(args) > 1 {
+		if output, ok := args[1].(io.Writer); ok {
+			return output
+		}
+	}
+	return os.Stdout
+}
+
+// accountHeader 和 accountColumn 用于在多账户模式下给表格增加账户列
+func accountHeader() string {
+	if multiAccountMode {
+		return "| 账户 "
+	}
+	return ""
+}
+
+func accountColumn(account string) string {
+	if multiAccountMode {
+		return "| " + account + " "
+	}
+	return ""
+}
+
+// applyDefaultRegion 在命令行未指定地域时使用账户的默认地域，指定为all时表示所有地域
+func applyDefaultRegion(acc *config.AccountConfig) {
+	regionFlag := flag.Lookup("region")
+	if regionFlag == nil {
+		return
+	}
+
+	regionSet := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "region" {
+			regionSet = true
+		}
+	})
+
+	if !regionSet {
+		regionFlag.Value.Set(config.DefaultRegion(acc))
+	}
+
+	if strings.ToLower(regionFlag.Value.String()) == "all" {
+		regionFlag.Value.Set("")
+	}
+}
+
+// outputFlag 注册输出格式参数，默认值为当前配置的输出格式
+func outputFlag(output *string) {
+	flag.StringVar(output, "output", string(config.ActiveProfile().Output), "输出格式，可选值为table、json")
+}
+
+// 输出过JSON或者YAML后不再打印操作结果，方便重定向到文件或者交给其它程序解析
+var rawPrinted bool
+
+func printJson(v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(data))
+	rawPrinted = true
+	return nil
+}
+
+func printYaml(v interface{}) error {
+	data, err := yaml.Marshal(v)
+	if err != nil {
+		return err
+	}
+	fmt.Print(string(data))
+	rawPrinted = true
+	return nil
+}
+
 func wellSize(size int64) string {
 	if size < 1024 {
 		return fmt.Sprintf("%d Byte", size)
@@ -72,6 +274,14 @@ func wellSize(size int64) string {
 	return fmt.Sprintf("%.2f TB", float64(size)/float64(1024*1024*1024*1024))
 }
 
+var stdinReader = bufio.NewReader(os.Stdin)
+
+// readLine 读取用户输入的一整行，和fmt.Scan不同，输入中可以包含空格
+func readLine() string {
+	line, _ := stdinReader.ReadString('\n')
+	return strings.TrimSpace(line)
+}
+
 type OperationFunc func(showHelp bool) error
 
 func SafeOperation(callback func() error) OperationFunc {
@@ -80,54 +290,67 @@ func SafeOperation(callback func() error) OperationFunc {
 	}
 }
 
+// confirmRisk 风险操作的确认，返回false表示用户取消了操作。需要先展示执行计划再确认的命令可以直接调用
+func confirmRisk(tips string) bool {
+	if dryRun || config.ActiveProfile().Confirm != config.ConfirmAll {
+		return true
+	}
+
+	fmt.Println("警告，下面的操作具有一定的风险性，请谨慎操作:")
+	if tips != "" {
+		fmt.Println(tips)
+	}
+	fmt.Print("请输入Y来确认是否进行下一步操作（不区分大小写，输入其他任意字符取消操作）:")
+	var confirm string
+	confirm = readLine()
+	fmt.Println("")
+	if strings.ToLower(confirm) == "y" {
+		return true
+	}
+	fmt.Println("操作已经取消")
+	return false
+}
+
 func RiskOperation(tips string, callback func() error) OperationFunc {
 
 	return func(showHelp bool) error {
-		if !showHelp {
-			fmt.Println("警告，下面的操作具有一定的风险性，请谨慎操作:")
-			if tips != "" {
-				fmt.Println(tips)
-			}
-			fmt.Print("请输入Y来确认是否进行下一步操作（不区分大小写，输入其他任意字符取消操作）:")
-			var confirm string
-			fmt.Scan(&confirm)
-			fmt.Println("")
-			if strings.ToLower(confirm) == "y" {
-				return callback()
-			} else {
-				fmt.Println("操作已经取消")
-			}
-		} else {
-			return callback()
+		if !showHelp && !confirmRisk(tips) {
+			return nil
 		}
-		return nil
+		return callback()
 	}
 
 }
 
+// confirmDanger 要求输入随机字符来确认危险操作，预览模式或者确认策略为none时直接返回true
+func confirmDanger(tips string) bool {
+	if dryRun || config.ActiveProfile().Confirm == config.ConfirmNone {
+		return true
+	}
+
+	fmt.Println("警告，下面的操作十分具备危险性，如非必要，强烈建议到控制台操作:")
+	if tips != "" {
+		fmt.Println(tips)
+	}
+	randStr := uuid.NewString()[:5]
+	fmt.Printf("请输入%s来确认是否进行下一步操作（输入错误会取消操作）:", randStr)
+	var confirmStr string
+	confirmStr = readLine()
+	fmt.Println("")
+	if confirmStr == randStr {
+		return true
+	}
+	fmt.Println("输入错误，操作已经取消")
+	return false
+}
+
 func DangerOperation(tips string, callback func() error) OperationFunc {
 
 	return func(showHelp bool) error {
-		if !showHelp {
-			fmt.Println("警告，下面的操作十分具备危险性，如非必要，强烈建议到控制台操作:")
-			if tips != "" {
-				fmt.Println(tips)
-			}
-			randStr := uuid.NewString()[:5]
-			fmt.Printf("请输入%s来确认是否进行下一步操作（输入错误会取消操作）:", randStr)
-			var confirmStr string
-			fmt.Scan(&confirmStr)
-			fmt.Println("")
-			if confirmStr == randStr {
-				return callback()
-			} else {
-				fmt.Println("输入错误，操作已经取消")
-			}
-		} else {
-			return callback()
+		if !showHelp && !confirmDanger(tips) {
+			return nil
 		}
-
-		return nil
+		return callback()
 	}
 
 }
@@ -204,6 +427,8 @@ func printHelp() {
 
 	fmt.Println()
 	fmt.Println("输入 lhbin [命令名称] --help 查看命令帮助信息")
+	fmt.Println("所有命令都支持 --config 参数指定配置文件路径，也可以通过环境变量LHBIN_CONFIG指定，默认为~/.lhbin/config.yaml")
+	fmt.Println("所有命令都支持 --dry-run 参数，只打印将要执行的操作，不会修改任何资源")
 }
 
 func printChildCommandHelp(childCommand string) {
@@ -241,7 +466,79 @@ func printChildCommandOperatorHelp(childCommand string, operatorName string) {
 	}
 }
 
+// 预览模式下只打印将要执行的操作，不会修改任何资源
+var dryRun bool
+
+// planned 在预览模式下打印将要执行的操作并返回true，调用方需要跳过实际的操作
+func planned(format string, a ...interface{}) bool {
+	return plannedTo(stdoutLog, format, a...)
+}
+
+// plannedTo 和planned相同，预览信息通过logf输出，daemon中会写入任务日志
+func plannedTo(logf logFunc, format string, a ...interface{}) bool {
+	if !dryRun {
+		return false
+	}
+	logf("[预览] "+format+"\n", a...)
+	return true
+}
+
+// extractGlobalArgs 从命令行参数中取出全局的--config和--dry-run参数，其余参数原样返回。--之后的参数不做处理
+func extractGlobalArgs(arguments []string) (string, bool, []string) {
+	configPath := ""
+	dryRun := false
+	rest := []string{}
+	for i := 0; i < len(arguments); i++ {
+		arg := arguments[i]
+		if arg == "--" {
+			rest = append(rest, arguments[i:]...)
+			break
+		}
+		switch {
+		case arg == "--config" || arg == "-config":
+			if i+1 < len(arguments) {
+				configPath = arguments[i+1]
+				i++
+			}
+		case strings.HasPrefix(arg, "--config="):
+			configPath = strings.TrimPrefix(arg, "--config=")
+		case strings.HasPrefix(arg, "-config="):
+			configPath = strings.TrimPrefix(arg, "-config=")
+		case arg == "--dry-run" || arg == "-dry-run":
+			dryRun = true
+		default:
+			rest = append(rest, arg)
+		}
+	}
+	return configPath, dryRun, rest
+}
+
+// mergeSubCommand 支持两级命令，例如 lhbin firewall template add 等同于 lhbin firewall-template add
+func mergeSubCommand(arguments []string) []string {
+	if len(arguments) < 3 {
+		return arguments
+	}
+
+	commandName, ok := commandAliasMap[arguments[1]]
+	if !ok {
+		return arguments
+	}
+
+	if subCommand, ok := commandAliasMap[commandName+"-"+arguments[2]]; ok {
+		merged := []string{arguments[0], subCommand}
+		return append(merged, arguments[3:]...)
+	}
+	return arguments
+}
+
 func ExecuteCommand() {
+	configPath, dry, args := extractGlobalArgs(os.Args)
+	dryRun = dry
+	if configPath != "" {
+		config.SetConfigPath(configPath)
+	}
+	os.Args = mergeSubCommand(args)
+
 	if len(os.Args) == 1 || (os.Args[1] == "--help" || os.Args[1] == "-help") {
 		printHelp()
 		return
@@ -263,10 +560,22 @@ func ExecuteCommand() {
 			if command, ok := commands[commandName]; ok {
 				if operatorName, ok := command.operatorAliasMap[operatorName]; ok {
 					if operator, ok := command.operators[operatorName]; ok {
+						if err := config.Load(); err != nil {
+							fmt.Printf("操作失败，原因是:%s \n", err.Error())
+							os.Exit(-1)
+						}
 						err := operator.operatorFunc(false)
 						if err != nil {
-							fmt.Printf("操作失败，原因是:%s \n", err.Error())
-						} else {
+							// 以非0状态退出，方便在脚本和定时任务中判断是否失败。已经输出过JSON或者YAML时失败信息写到标准错误，避免破坏输出的格式
+							if rawPrinted {
+								fmt.Fprintf(os.Stderr, "操作失败，原因是:%s \n", err.Error())
+							} else {
+								fmt.Printf("操作失败，原因是:%s \n", err.Error())
+							}
+							os.Exit(1)
+						} else if dryRun {
+							fmt.Println("预览结束，没有修改任何资源.....")
+						} else if !rawPrinted {
 							fmt.Println("操作成功.....")
 						}
 						return
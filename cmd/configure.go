@@ -0,0 +1,97 @@
+package cmd
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/lixiaofei123/lhbin/config"
+)
+
+const ConfigCommandName string = "config"
+
+func init() {
+
+	RegisterChildCommand(ConfigCommandName, "管理配置，支持多套配置之间切换", []string{"profile"})
+	RegisterChildCommandOperator(ConfigCommandName, "get", "查看配置项", []string{}, SafeOperation(GetConfig))
+	RegisterChildCommandOperator(ConfigCommandName, "set", "修改配置项", []string{}, SafeOperation(SetConfig))
+	RegisterChildCommandOperator(ConfigCommandName, "use", "切换当前使用的配置", []string{"switch"}, SafeOperation(UseConfig))
+}
+
+func GetConfig() error {
+
+	var profile string
+	var key string
+
+	flag.StringVar(&profile, "profile", config.ActiveProfileName(), "配置名称，不填则为当前使用的配置")
+	flag.StringVar(&key, "key", "", "配置项名称，可选值为account、region、output、parallelism、confirm，不填则列出全部配置项")
+	flag.CommandLine.Parse(os.Args[3:])
+
+	if key != "" {
+		value, err := config.GetProfileValue(profile, key)
+		if err != nil {
+			return err
+		}
+		fmt.Println(value)
+		return nil
+	}
+
+	fmt.Printf("当前使用的配置为%s，可用的配置有%v\n", config.ActiveProfileName(), config.ListProfiles())
+	fmt.Println("------------------------------------------")
+	fmt.Println("| 配置项 | 值 |")
+	fmt.Println("------------------------------------------")
+	for _, key := range config.ProfileKeys {
+		value, err := config.GetProfileValue(profile, key)
+		if err != nil {
+			value = ""
+		}
+		fmt.Println("|", key, "|", value, "|")
+		fmt.Println("------------------------------------------")
+	}
+
+	return nil
+}
+
+func SetConfig() error {
+
+	var profile string
+	var account string
+	var key string
+	var value string
+
+	flag.StringVar(&profile, "profile", config.ActiveProfileName(), "配置名称，不填则为当前使用的配置")
+	flag.StringVar(&account, "account", "", "账号名称，仅在修改region时有效，不填则为配置的默认账号")
+	flag.StringVar(&key, "key", "", "配置项名称，可选值为account、region、output、parallelism、confirm")
+	flag.StringVar(&value, "value", "", "配置项的值，留空表示清除该配置项(output、parallelism、confirm除外)")
+	flag.CommandLine.Parse(os.Args[3:])
+
+	checkArg(&key, "配置项名称不能为空")
+
+	err := config.SetProfileValue(profile, account, key, value)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("配置%s的%s已修改为%s\n", profile, key, value)
+	return nil
+}
+
+func UseConfig() error {
+
+	var profile string
+
+	flag.StringVar(&profile, "profile", "", "配置名称，不存在时会自动创建")
+	flag.CommandLine.Parse(os.Args[3:])
+
+	checkArg(&profile, "配置名称不能为空")
+
+	if err := config.UseProfile(profile); err != nil {
+		return err
+	}
+	fmt.Printf("已切换到配置%s\n", profile)
+
+	if os.Getenv(config.ProfileEnv) != "" {
+		fmt.Printf("注意：已设置环境变量%s，在取消该环境变量之前切换后的配置不会生效\n", config.ProfileEnv)
+	}
+	return nil
+}
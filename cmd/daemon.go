@@ -0,0 +1,416 @@
+package cmd
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/lixiaofei123/lhbin/config"
+	"github.com/lixiaofei123/lhbin/driver"
+)
+
+const DaemonCommandName string = "daemon"
+
+func init() {
+
+	RegisterChildCommand(DaemonCommandName, "按照定时任务文件在后台定时执行快照轮转、创建镜像、到期检查等操作", []string{})
+	RegisterChildCommandOperator(DaemonCommandName, "start", "启动daemon，按照cron表达式执行任务，收到SIGTERM或者SIGINT后等待正在执行的任务结束再退出", []string{"run"}, SafeOperation(StartDaemon))
+	RegisterChildCommandOperator(DaemonCommandName, "check", "检查定时任务文件并列出每个任务下一次执行的时间", []string{}, SafeOperation(CheckSchedule))
+	RegisterChildCommandOperator(DaemonCommandName, "exec", "立即执行定时任务文件中的指定任务，方便测试", []string{}, SafeOperation(ExecScheduleJob))
+}
+
+// jobAction 定时任务可以执行的操作，对每个目标实例调用一次
+type jobAction func(target *instanceTarget, params *jobParams, logf logFunc) error
+
+var jobActions = map[string]jobAction{
+	"snapshot-rotate":  snapshotRotateAction,
+	"blueprint-create": blueprintCreateAction,
+	"expiry-check":     expiryCheckAction,
+	"traffic-check":    trafficCheckAction,
+}
+
+func jobActionNames() []string {
+	names := []string{}
+	for name := range jobActions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// jobParams 读取任务参数，未设置时使用默认值
+type jobParams struct {
+	values map[string]string
+}
+
+func (params *jobParams) str(key, defaultValue string) string {
+	if value, ok := params.values[key]; ok && value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+func (params *jobParams) int(key string, defaultValue int) (int, error) {
+	value := params.str(key, "")
+	if value == "" {
+		return defaultValue, nil
+	}
+	result, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("参数%s必须为整数", key)
+	}
+	return result, nil
+}
+
+func (params *jobParams) duration(key string, defaultValue time.Duration) (time.Duration, error) {
+	value := params.str(key, "")
+	if value == "" {
+		return defaultValue, nil
+	}
+	result, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("参数%s必须为时间长度，例如30m", key)
+	}
+	return result, nil
+}
+
+func snapshotRotateAction(target *instanceTarget, params *jobParams, logf logFunc) error {
+	retention := &snapshotRetention{}
+	var err error
+	if retention.keepLast, err = params.int("keep-last", 3); err != nil {
+		return err
+	}
+	if retention.keepDaily, err = params.int("keep-daily", 0); err != nil {
+		return err
+	}
+	if retention.keepWeekly, err = params.int("keep-weekly", 0); err != nil {
+		return err
+	}
+	if retention.empty() {
+		return errors.New("keep-last、keep-daily、keep-weekly至少需要设置一个大于0的值")
+	}
+	timeout, err := params.duration("timeout", 30*time.Minute)
+	if err != nil {
+		return err
+	}
+
+	return rotateInstanceSnapshots(target.cdriver, target.region, target.insid, params.str("prefix", defaultRotatePrefix), retention, timeout, logf)
+}
+
+func blueprintCreateAction(target *instanceTarget, params *jobParams, logf logFunc) error {
+	name := params.str("prefix", "lhbin-backup-") + time.Now().Format("20060102-150405")
+	if plannedTo(logf, "将为%s地域的实例%s创建镜像%s", target.region, target.insid, name) {
+		return nil
+	}
+
+	blueprint, err := target.cdriver.CreateBlueprint(target.region, target.insid, name, params.str("desc", "lhbin daemon自动创建"))
+	if err != nil {
+		return err
+	}
+	logf("%s地域的实例%s(%s)已开始创建镜像%s(%s)\n", target.region, target.name, target.insid, name, blueprint.Blueprint)
+	return nil
+}
+
+func expiryCheckAction(target *instanceTarget, params *jobParams, logf logFunc) error {
+	days, err := params.int("days", 7)
+	if err != nil {
+		return err
+	}
+
+	insinfo, err := target.cdriver.InstanceInfo(target.region, target.insid)
+	if err != nil {
+		return err
+	}
+
+	if insinfo.ExpiredTime.IsZero() {
+		return nil
+	}
+	left := time.Until(insinfo.ExpiredTime)
+	if left < time.Duration(days)*24*time.Hour {
+		logf("[告警] %s地域的实例%s(%s)将于%s到期，剩余%d天\n", target.region, target.name, target.insid, insinfo.ExpiredTime.Format("2006-01-02 15:04:05"), int(left.Hours()/24))
+	}
+	return nil
+}
+
+func trafficCheckAction(target *instanceTarget, params *jobParams, logf logFunc) error {
+	threshold, err := params.int("threshold", 80)
+	if err != nil {
+		return err
+	}
+
+	tps, err := target.cdriver.InstancesTrafficPackages(target.region, []string{target.insid})
+	if err != nil {
+		return err
+	}
+
+	for _, tp := range tps {
+		if tp.Total > 0 && tp.Used*100 >= tp.Total*int64(threshold) {
+			logf("[告警] %s地域的实例%s(%s)流量包已使用%s，总流量%s，超过%d%%\n", target.region, target.name, target.insid, wellSize(tp.Used), wellSize(tp.Total), threshold)
+		}
+	}
+	return nil
+}
+
+// scheduledJob 解析后的定时任务
+type scheduledJob struct {
+	*config.ScheduleJob
+	schedule *cronSchedule
+	action   jobAction
+	running  bool
+}
+
+func loadScheduledJobs(path string) ([]*scheduledJob, error) {
+	if path == "" {
+		var err error
+		path, err = config.DefaultSchedulePath()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	schedule, err := config.LoadSchedule(path)
+	if err != nil {
+		return nil, err
+	}
+
+	jobs := []*scheduledJob{}
+	for _, job := range schedule.Jobs {
+		cronSchedule, err := parseCron(job.Cron)
+		if err != nil {
+			return nil, fmt.Errorf("任务%s的%s", job.Name, err.Error())
+		}
+		action, ok := jobActions[job.Action]
+		if !ok {
+			return nil, fmt.Errorf("任务%s的操作%s不存在，可选值为%s", job.Name, job.Action, strings.Join(jobActionNames(), "、"))
+		}
+		if job.Filter != "" {
+			if _, err := parseInstanceFilter(job.Filter); err != nil {
+				return nil, fmt.Errorf("任务%s的%s", job.Name, err.Error())
+			}
+		}
+		jobs = append(jobs, &scheduledJob{ScheduleJob: job, schedule: cronSchedule, action: action})
+	}
+	return jobs, nil
+}
+
+// runJob 找出任务的目标实例并依次执行操作，单个实例失败不影响其它实例
+func runJob(job *scheduledJob, logger *log.Logger) error {
+	logf := func(format string, a ...interface{}) {
+		logger.Printf("[%s] "+format, append([]interface{}{job.Name}, a...)...)
+	}
+
+	acc, err := config.FindAcount(config.QQCloud, job.Account)
+	if err != nil {
+		return err
+	}
+	cdriver, err := driver.GetDriver(acc)
+	if err != nil {
+		return err
+	}
+
+	region := job.Region
+	if region == "" {
+		region = config.DefaultRegion(acc)
+	}
+	if strings.ToLower(region) == "all" {
+		region = ""
+	}
+
+	targets, err := collectInstancesTo(&accountDriver{account: acc.Account, region: region, driver: cdriver}, strings.Join(job.Instances, ","), logf)
+	if err != nil {
+		return err
+	}
+
+	var filter instanceFilter
+	if job.Filter != "" {
+		filter, err = parseInstanceFilter(job.Filter)
+		if err != nil {
+			return err
+		}
+	}
+
+	failed := 0
+	for _, target := range targets {
+		if filter != nil && !filter.match(target) {
+			continue
+		}
+		if err := job.action(target, &jobParams{values: job.Params}, logf); err != nil {
+			logf("%s地域的实例%s(%s)执行失败，原因是:%s\n", target.region, target.name, target.insid, err.Error())
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("有%d个实例执行失败", failed)
+	}
+	return nil
+}
+
+// newJobLogger 任务日志同时输出到终端和日志文件
+func newJobLogger(logPath string) (*log.Logger, io.Closer, error) {
+	if logPath == "" {
+		dir, err := config.ConfigDir()
+		if err != nil {
+			return nil, nil, err
+		}
+		logPath = filepath.Join(dir, "daemon.log")
+	}
+
+	if err := os.MkdirAll(filepath.Dir(logPath), 0700); err != nil {
+		return nil, nil, err
+	}
+
+	file, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, nil, err
+	}
+	return log.New(io.MultiWriter(os.Stdout, file), "", log.LstdFlags), file, nil
+}
+
+func StartDaemon() error {
+
+	var file string
+	var logPath string
+
+	flag.StringVar(&file, "file", "", "定时任务文件路径，不填则为配置目录下的schedule.yaml")
+	flag.StringVar(&logPath, "log", "", "任务日志文件路径，不填则为配置目录下的daemon.log")
+	flag.CommandLine.Parse(os.Args[3:])
+
+	jobs, err := loadScheduledJobs(file)
+	if err != nil {
+		return err
+	}
+
+	logger, closer, err := newJobLogger(logPath)
+	if err != nil {
+		return err
+	}
+	defer closer.Close()
+
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+
+	logger.Printf("daemon已启动，共%d个任务", len(jobs))
+
+	for {
+		now := time.Now()
+		tick := now.Truncate(time.Minute).Add(time.Minute)
+		timer := time.NewTimer(tick.Sub(now))
+
+		select {
+		case sig := <-signals:
+			timer.Stop()
+			logger.Printf("收到%s信号，等待正在执行的任务结束，再次发送信号将立即退出", sig)
+			go func() {
+				<-signals
+				logger.Printf("再次收到退出信号，立即退出")
+				os.Exit(1)
+			}()
+			wg.Wait()
+			logger.Printf("daemon已退出")
+			rawPrinted = true
+			return nil
+		case <-timer.C:
+		}
+
+		for _, job := range jobs {
+			if !job.schedule.match(tick) {
+				continue
+			}
+
+			lock.Lock()
+			if job.running {
+				lock.Unlock()
+				logger.Printf("[%s] 上一次执行还没有结束，跳过本次执行", job.Name)
+				continue
+			}
+			job.running = true
+			lock.Unlock()
+
+			wg.Add(1)
+			go func(job *scheduledJob) {
+				defer wg.Done()
+				defer func() {
+					lock.Lock()
+					job.running = false
+					lock.Unlock()
+				}()
+
+				start := time.Now()
+				logger.Printf("[%s] 开始执行%s", job.Name, job.Action)
+				if err := runJob(job, logger); err != nil {
+					logger.Printf("[%s] 执行失败，耗时%s，原因是:%s", job.Name, time.Since(start).Round(time.Second), err.Error())
+				} else {
+					logger.Printf("[%s] 执行完成，耗时%s", job.Name, time.Since(start).Round(time.Second))
+				}
+			}(job)
+		}
+	}
+}
+
+func CheckSchedule() error {
+
+	var file string
+
+	flag.StringVar(&file, "file", "", "定时任务文件路径，不填则为配置目录下的schedule.yaml")
+	flag.CommandLine.Parse(os.Args[3:])
+
+	jobs, err := loadScheduledJobs(file)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("------------------------------------------")
+	fmt.Println("| 任务名称 | cron | 操作 | 账户 | 地域 | 下次执行时间 |")
+	fmt.Println("------------------------------------------")
+	for _, job := range jobs {
+		next := job.schedule.next(time.Now())
+		nextStr := "一年内不会执行"
+		if !next.IsZero() {
+			nextStr = next.Format("2006-01-02 15:04")
+		}
+		fmt.Println("|", job.Name, "|", job.Cron, "|", job.Action, "|", job.Account, "|", job.Region, "|", nextStr, "|")
+		fmt.Println("------------------------------------------")
+	}
+
+	return nil
+}
+
+func ExecScheduleJob() error {
+
+	var file string
+	var name string
+
+	flag.StringVar(&file, "file", "", "定时任务文件路径，不填则为配置目录下的schedule.yaml")
+	flag.StringVar(&name, "job", "", "任务名称")
+	flag.CommandLine.Parse(os.Args[3:])
+
+	checkArg(&name, "任务名称不能为空")
+
+	jobs, err := loadScheduledJobs(file)
+	if err != nil {
+		return err
+	}
+
+	for _, job := range jobs {
+		if job.Name == name {
+			return runJob(job, log.New(os.Stdout, "", log.LstdFlags))
+		}
+	}
+
+	return fmt.Errorf("任务%s不存在", name)
+}
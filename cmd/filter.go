@@ -0,0 +1,49 @@
+package cmd
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// instanceFilter 实例过滤条件，格式为 key=pattern，多个条件用逗号隔开，条件之间为并且的关系。
+// key可选值为name、id、region，pattern支持*和?通配符
+type instanceFilter map[string]string
+
+func parseInstanceFilter(filter string) (instanceFilter, error) {
+	f := instanceFilter{}
+	if filter == "" {
+		return f, nil
+	}
+
+	for _, cond := range strings.Split(filter, ",") {
+		kv := strings.SplitN(cond, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("过滤条件%s格式错误，应为key=pattern", cond)
+		}
+		key := strings.TrimSpace(kv[0])
+		pattern := strings.TrimSpace(kv[1])
+		if key != "name" && key != "id" && key != "region" {
+			return nil, fmt.Errorf("不支持的过滤条件%s，可选值为name、id、region", key)
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("过滤条件%s的通配符格式错误", cond)
+		}
+		f[key] = pattern
+	}
+	return f, nil
+}
+
+func (f instanceFilter) match(target *instanceTarget) bool {
+	values := map[string]string{
+		"name":   target.name,
+		"id":     target.insid,
+		"region": target.region,
+	}
+	for key, pattern := range f {
+		if ok, _ := path.Match(pattern, values[key]); !ok {
+			return false
+		}
+	}
+	return true
+}
@@ -2,10 +2,14 @@ package cmd
 
 import (
 	"errors"
+	"flag"
 	"fmt"
+	"io/ioutil"
+	"os"
 	"strings"
 
 	"github.com/lixiaofei123/lhbin/driver"
+	"gopkg.in/yaml.v2"
 )
 
 const FirewallCommandName string = "firewall"
@@ -16,6 +20,8 @@ func init() {
 	RegisterChildCommandOperator(FirewallCommandName, "list", "列出符合条件的实例的防火墙信息", []string{}, SafeOperation(ListFirewalls))
 	RegisterChildCommandOperator(FirewallCommandName, "del", "列出符合条件的实例的防火墙信息中指定的防火墙规则", []string{"delete"}, SafeOperation(DeleteFirewallRules))
 	RegisterChildCommandOperator(FirewallCommandName, "add", "向符合条件的实例的防火墙信息中添加新的防火墙规则", []string{"create"}, SafeOperation(AddFirewallRules))
+	RegisterChildCommandOperator(FirewallCommandName, "edit", "修改符合条件的实例中匹配的单条防火墙规则，其它规则保持不变", []string{"modify"}, SafeOperation(EditFirewallRule))
+	RegisterChildCommandOperator(FirewallCommandName, "apply", "根据规则文件计算符合条件的实例需要新增和删除的防火墙规则，只修改有差异的部分", []string{}, SafeOperation(ApplyFirewallRules))
 	RegisterChildCommandOperator(FirewallCommandName, "update", "重置符合条件的实例的防火墙信息中的防火墙规则(会删除原有的全部规则并应用新添加的规则)", []string{"reset"}, RiskOperation("此操作会删除原有的所有的防火墙规则", UpdateFirewallRules))
 }
 
@@ -26,6 +32,7 @@ func ListFirewalls() error {
 			fmt.Printf("%s地域下的%s(%s)防火墙规则查询失败，原因是:%s \n", region, name, insid, err.Error())
 		} else {
 			fmt.Println("---------------------------------------")
+			fmt.Print(accountHeader() + accountColumn(accountOf(args)))
 			fmt.Println("| 地域 |", region, "| 实例名称 |", name, "| 实例ID |", insid, "|")
 			fmt.Println("---------------------------------------")
 			fmt.Println("| 协议 | 端口 | 来源 | 策略 | 描述 |")
@@ -45,15 +52,48 @@ func PrintFileWallRuleTips() {
 	fmt.Println("protocol|port|cidr|action|desc")
 	fmt.Println("其中，protocal的取值是TCP、UDP、ICMP、ALL")
 	fmt.Println("port可以是端口1,端口2这样的形式，也可以是起始端口-结束端口，端口的范围是1-65535，也可以输入ALL代表1-65535")
-	fmt.Println("cidr为ip或者ip/port，例如0.0.0.0/0或者0.0.0.0，可以不填，默认为0.0.0.0/0")
+	fmt.Println("cidr为ip或者网段，支持IPv4和IPv6，例如0.0.0.0/0或者0.0.0.0，可以不填，默认为0.0.0.0/0")
 	fmt.Println("action的取值为ACCEPT或者DROP，可以不填，默认为ACCEPT")
-	fmt.Println("desc可以不填，默认为空。如果是删除防火墙规则，此项留空")
+	fmt.Println("desc可以不填，默认为空，最长64个字符。如果是删除防火墙规则，此项留空")
+	fmt.Println("协议为ICMP或者ALL时，端口只能为ALL")
 
 	fmt.Println("示例1 TCP|8080-8090|0.0.0.0/0|ACCEPT|测试")
 	fmt.Println("示例2 TCP|8080-8090")
 	fmt.Println()
 }
 
+// checkFirewallRules 规范化并检查规则列表，打印发现的问题，存在错误时返回error
+func checkFirewallRules(rules []*driver.FirewallRule) error {
+	issues, err := driver.ValidateFirewallRules(rules)
+	if err != nil {
+		return err
+	}
+
+	hasError := false
+	for _, issue := range issues {
+		fmt.Printf("[%s] %s\n", issue.Level, issue.Message)
+		if issue.Level == driver.FirewallRuleError {
+			hasError = true
+		}
+	}
+
+	if hasError {
+		return errors.New("防火墙规则存在错误，请修改后重试")
+	}
+	return nil
+}
+
+// planRules 在预览模式下打印将要操作的防火墙规则
+func planRules(action string, rules []*driver.FirewallRule) {
+	if !dryRun {
+		return
+	}
+	planned("将%s以下防火墙规则:", action)
+	for _, rule := range rules {
+		fmt.Println("    ", rule.Protocol, "|", rule.Port, "|", rule.CidrBlock, "|", rule.Action, "|", rule.Description)
+	}
+}
+
 func firewallRuleFromStr(ruleStr string) (*driver.FirewallRule, error) {
 
 	attrs := strings.Split(ruleStr, "|")
@@ -80,6 +120,10 @@ func firewallRuleFromStr(ruleStr string) (*driver.FirewallRule, error) {
 		rule.Description = attrs[4]
 	}
 
+	if err := driver.NormalizeFirewallRule(rule); err != nil {
+		return nil, err
+	}
+
 	return rule, nil
 
 }
@@ -90,13 +134,15 @@ func DeleteFirewallRules() error {
 
 	fmt.Println("请输入你要删除的防火墙规则:")
 	var rule string
-	fmt.Scan(&rule)
+	rule = readLine()
 
 	deleteRule, err := firewallRuleFromStr(rule)
 	if err != nil {
 		return err
 	}
 
+	planRules("删除", []*driver.FirewallRule{deleteRule})
+
 	return batchOperatorInstances("删除防火墙规则", true, func(region string, insids string) error { return nil }, func(cdriver driver.Driver, region, name, insid string, args ...interface{}) error {
 		return cdriver.DeleteFirewallRules(region, insid, []*driver.FirewallRule{deleteRule})
 	})
@@ -108,18 +154,109 @@ func AddFirewallRules() error {
 
 	fmt.Println("请输入你要添加的防火墙规则:")
 	var rule string
-	fmt.Scan(&rule)
+	rule = readLine()
 
 	addRule, err := firewallRuleFromStr(rule)
 	if err != nil {
 		return err
 	}
 
+	planRules("添加", []*driver.FirewallRule{addRule})
+
 	return batchOperatorInstances("添加防火墙规则", true, func(region string, insids string) error { return nil }, func(cdriver driver.Driver, region, name, insid string, args ...interface{}) error {
 		return cdriver.AddFirewallRules(region, insid, []*driver.FirewallRule{addRule})
 	})
 }
 
+func EditFirewallRule() error {
+
+	var match string
+	var protocol string
+	var port string
+	var cidr string
+	var action string
+	var desc string
+
+	flag.StringVar(&match, "match", "", "需要修改的规则，写法为protocol|port|cidr|action，cidr不填默认为0.0.0.0/0，action不填默认为ACCEPT，例如TCP|22|0.0.0.0/0")
+	flag.StringVar(&protocol, "protocol", "", "新的协议，不填则不修改")
+	flag.StringVar(&port, "port", "", "新的端口，不填则不修改")
+	flag.StringVar(&cidr, "cidr", "", "新的来源IP或者网段，不填则不修改")
+	flag.StringVar(&action, "action", "", "新的策略，不填则不修改")
+	flag.StringVar(&desc, "desc", "", "新的描述，不填则不修改。只修改描述时不会影响其它规则")
+
+	var matchRule *driver.FirewallRule
+
+	return batchOperatorInstances("修改防火墙规则", true, func(region string, insids string) error {
+		checkArg(&match, "需要修改的规则不能为空")
+
+		var err error
+		matchRule, err = firewallRuleFromStr(match)
+		if err != nil {
+			return err
+		}
+
+		changed := false
+		flag.Visit(func(f *flag.Flag) {
+			switch f.Name {
+			case "protocol", "port", "cidr", "action", "desc":
+				changed = true
+			}
+		})
+		if !changed {
+			return errors.New("至少需要修改规则的一项内容")
+		}
+		return nil
+	}, func(cdriver driver.Driver, region, name, insid string, args ...interface{}) error {
+		current, err := cdriver.ListFirewallRules(region, insid)
+		if err != nil {
+			return err
+		}
+
+		var oldRule *driver.FirewallRule
+		for _, rule := range current {
+			if driver.FirewallRuleKey(rule) == driver.FirewallRuleKey(matchRule) {
+				oldRule = rule
+				break
+			}
+		}
+		if oldRule == nil {
+			return errors.New("没有找到匹配的规则")
+		}
+
+		newRule := *oldRule
+		flag.Visit(func(f *flag.Flag) {
+			switch f.Name {
+			case "protocol":
+				newRule.Protocol = driver.FirewallRuleProtocol(protocol)
+			case "port":
+				newRule.Port = port
+			case "cidr":
+				newRule.CidrBlock = cidr
+			case "action":
+				newRule.Action = driver.FirewallRuleAction(action)
+			case "desc":
+				newRule.Description = desc
+			}
+		})
+		if err := driver.NormalizeFirewallRule(&newRule); err != nil {
+			return err
+		}
+
+		if driver.FirewallRuleKey(&newRule) != driver.FirewallRuleKey(oldRule) {
+			for _, rule := range current {
+				if rule != oldRule && driver.FirewallRuleKey(rule) == driver.FirewallRuleKey(&newRule) {
+					return errors.New("修改后的规则和已有的规则重复")
+				}
+			}
+		}
+
+		fmt.Println("  -", oldRule.Protocol, "|", oldRule.Port, "|", oldRule.CidrBlock, "|", oldRule.Action, "|", oldRule.Description)
+		fmt.Println("  +", newRule.Protocol, "|", newRule.Port, "|", newRule.CidrBlock, "|", newRule.Action, "|", newRule.Description)
+
+		return cdriver.EditFirewallRule(region, insid, oldRule, &newRule)
+	})
+}
+
 func UpdateFirewallRules() error {
 
 	PrintFileWallRuleTips()
@@ -127,7 +264,7 @@ func UpdateFirewallRules() error {
 	fmt.Print("是否添加默认规则，默认规则包含Ping、80、443、22、3389 (Y/N):")
 	newrules := []*driver.FirewallRule{}
 	var defalutRule string
-	fmt.Scan(&defalutRule)
+	defalutRule = readLine()
 	fmt.Println("")
 	if strings.ToLower(defalutRule) == "y" {
 		newrules = append(newrules, &driver.FirewallRule{
@@ -176,7 +313,7 @@ func UpdateFirewallRules() error {
 
 		fmt.Print("是否继续输入(Y/N):")
 		var beContinue string
-		fmt.Scan(&beContinue)
+		beContinue = readLine()
 		fmt.Println("")
 		if strings.ToLower(beContinue) != "y" {
 			break
@@ -184,19 +321,236 @@ func UpdateFirewallRules() error {
 
 		fmt.Println("请输入你要添加的防火墙规则:")
 		var rule string
-		fmt.Scan(&rule)
+		rule = readLine()
 
 		addRule, err := firewallRuleFromStr(rule)
 		if err != nil {
-			fmt.Println("防火墙规则输入错误:")
+			fmt.Println("防火墙规则输入错误:", err.Error())
 		} else {
 			newrules = append(newrules, addRule)
 		}
 
 	}
 
+	if err := checkFirewallRules(newrules); err != nil {
+		return err
+	}
+
+	planRules("删除原有的全部规则并应用", newrules)
+
 	return batchOperatorInstances("更新防火墙规则", true, func(region string, insids string) error { return nil }, func(cdriver driver.Driver, region, name, insid string, args ...interface{}) error {
 		return cdriver.UpdateFirewallRules(region, insid, newrules)
 	})
 
 }
+
+// loadFirewallRules 从YAML或者JSON文件中读取防火墙规则列表并检查
+func loadFirewallRules(path string) ([]*driver.FirewallRule, error) {
+	rules, err := readFirewallRules(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkFirewallRules(rules); err != nil {
+		return nil, err
+	}
+
+	return rules, nil
+}
+
+func readFirewallRules(path string) ([]*driver.FirewallRule, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// JSON是YAML的子集，所以两种格式都可以直接用yaml解析
+	rules := []*driver.FirewallRule{}
+	if err := yaml.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("规则文件%s格式错误:%s", path, err.Error())
+	}
+
+	for index, rule := range rules {
+		if rule == nil {
+			return nil, fmt.Errorf("规则文件%s中第%d条规则为空", path, index+1)
+		}
+	}
+
+	return rules, nil
+}
+
+// composeFirewallRules 合并模板和规则文件中的规则，重复的规则只保留第一次出现的
+func composeFirewallRules(templates, file string) ([]*driver.FirewallRule, error) {
+	rules := []*driver.FirewallRule{}
+	if templates != "" {
+		templateRules, err := loadTemplateRules(templates)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, templateRules...)
+	}
+
+	if file != "" {
+		fileRules, err := readFirewallRules(file)
+		if err != nil {
+			return nil, err
+		}
+		seen := map[string]bool{}
+		for _, rule := range rules {
+			seen[driver.FirewallRuleKey(rule)] = true
+		}
+		for _, rule := range fileRules {
+			if !seen[driver.FirewallRuleKey(rule)] {
+				rules = append(rules, rule)
+			}
+		}
+	}
+
+	if err := checkFirewallRules(rules); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+func printFirewallRuleDiff(diff *driver.FirewallRuleDiff) {
+	for _, rule := range diff.Add {
+		fmt.Println("  +", rule.Protocol, "|", rule.Port, "|", rule.CidrBlock, "|", rule.Action, "|", rule.Description)
+	}
+	for _, rule := range diff.Remove {
+		fmt.Println("  -", rule.Protocol, "|", rule.Port, "|", rule.CidrBlock, "|", rule.Action, "|", rule.Description)
+	}
+	for _, rule := range diff.Keep {
+		fmt.Println("  =", rule.Protocol, "|", rule.Port, "|", rule.CidrBlock, "|", rule.Action, "|", rule.Description)
+	}
+}
+
+// applyFirewallRuleDiff 先添加再删除，避免中间状态下需要的端口被关闭
+func applyFirewallRuleDiff(cdriver driver.Driver, region, insid string, diff *driver.FirewallRuleDiff) error {
+	if len(diff.Add) > 0 {
+		if err := cdriver.AddFirewallRules(region, insid, diff.Add); err != nil {
+			return err
+		}
+	}
+	if len(diff.Remove) > 0 {
+		if err := cdriver.DeleteFirewallRules(region, insid, diff.Remove); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func ApplyFirewallRules() error {
+
+	var file string
+	var templates string
+	var region string
+	var insid string
+	var insids string
+	var force bool
+
+	cdrivers, err := parseAndGetDrivers(func() {
+		flag.StringVar(&file, "file", "", "规则文件路径，支持YAML和JSON格式，内容为规则列表，每条规则包含protocol、port、cidr、action、description")
+		flag.StringVar(&templates, "template", "", "防火墙模板名称，多个模板用逗号隔开，会按顺序合并，同时设置file参数时文件中的规则排在模板之后")
+		flag.StringVar(&region, "region", "", "实例所在地域，不填则为账户的默认地域，未设置默认地域或者填写all则为所有地域")
+		flag.StringVar(&insid, "insid", "", "实例ID，如果设置此值，则会忽略insids参数")
+		flag.StringVar(&insids, "insids", "", "实例ID，多个请用逗号隔开。如果不填则默认为所选择地域下的所有实例")
+		flag.BoolVar(&force, "f", false, "强制执行，忽略二次确认")
+	}, func() error {
+		if insid != "" {
+			insids = insid
+		}
+		if templates == "" {
+			checkArg(&file, "规则文件路径和模板名称不能都为空")
+		}
+		return nil
+	}, os.Args[3:])
+
+	if err != nil {
+		return err
+	}
+
+	rules, err := composeFirewallRules(templates, file)
+	if err != nil {
+		return err
+	}
+
+	// 先计算所有实例的差异并展示，确认后再修改
+	drifts := []*firewallDrift{}
+	for _, cdriver := range cdrivers {
+		targets, err := collectInstances(cdriver, insids)
+		if err != nil {
+			if !multiAccountMode {
+				return err
+			}
+			fmt.Printf("查询账户%s下的实例失败，原因是:%s \n", cdriver.account, err.Error())
+			continue
+		}
+		for _, target := range targets {
+			drift := &firewallDrift{target: target}
+			current, err := target.cdriver.ListFirewallRules(target.region, target.insid)
+			if err != nil {
+				drift.err = err
+			} else {
+				drift.diff = driver.DiffFirewallRules(current, rules)
+			}
+			drifts = append(drifts, drift)
+		}
+	}
+
+	changed := 0
+	for _, drift := range drifts {
+		target := drift.target
+		prefix := ""
+		if multiAccountMode {
+			prefix = "账户" + target.account
+		}
+		if drift.err != nil {
+			fmt.Printf("%s%s地域下的%s(%s)防火墙规则查询失败，原因是:%s \n", prefix, target.region, target.name, target.insid, drift.err.Error())
+			continue
+		}
+		fmt.Printf("%s%s地域下的%s(%s)防火墙规则差异(+新增 -删除 =保持不变):\n", prefix, target.region, target.name, target.insid)
+		printFirewallRuleDiff(drift.diff)
+		if drift.diff.Empty() {
+			fmt.Println("防火墙规则没有差异，无需修改")
+		} else {
+			changed++
+		}
+	}
+
+	if changed == 0 {
+		return nil
+	}
+
+	if dryRun {
+		for _, drift := range drifts {
+			if drift.err == nil && !drift.diff.Empty() {
+				planned("将对%s地域下的%s(%s)新增%d条规则，删除%d条规则", drift.target.region, drift.target.name, drift.target.insid, len(drift.diff.Add), len(drift.diff.Remove))
+			}
+		}
+		return nil
+	}
+
+	if !force && !confirmRisk(fmt.Sprintf("将修改%d个实例的防火墙规则，规则文件中没有的规则会被删除", changed)) {
+		return nil
+	}
+
+	failed := []string{}
+	for _, drift := range drifts {
+		if drift.err != nil || drift.diff.Empty() {
+			continue
+		}
+		target := drift.target
+		err := applyFirewallRuleDiff(target.cdriver, target.region, target.insid, drift.diff)
+		if err != nil {
+			fmt.Printf("%s地域下的%s(%s)防火墙规则修改失败，原因是:%s \n", target.region, target.name, target.insid, err.Error())
+			failed = append(failed, target.insid)
+		} else {
+			fmt.Printf("%s地域下的%s(%s)防火墙规则修改成功，新增%d条，删除%d条 \n", target.region, target.name, target.insid, len(drift.diff.Add), len(drift.diff.Remove))
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("以下实例的防火墙规则修改失败:%s", strings.Join(failed, ","))
+	}
+	return nil
+}
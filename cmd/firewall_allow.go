@@ -0,0 +1,218 @@
+package cmd
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/lixiaofei123/lhbin/config"
+	"github.com/lixiaofei123/lhbin/driver"
+)
+
+// allowMeMarker allow-me添加的规则会在描述中带上此标记和过期时间，revoke-expired据此找到需要删除的规则
+const allowMeMarker string = "allow-me@"
+
+const allowMeTimeLayout string = "2006-01-02T15:04Z"
+
+func init() {
+	RegisterChildCommandOperator(FirewallCommandName, "allow-me", "向符合条件的实例添加只允许本机公网IP访问指定端口的临时规则", []string{}, SafeOperation(AllowMeFirewallRule))
+	RegisterChildCommandOperator(FirewallCommandName, "revoke-expired", "删除符合条件的实例上通过allow-me添加并且已经过期的规则", []string{}, SafeOperation(RevokeExpiredFirewallRules))
+}
+
+// detectPublicIp 请求url获取调用方的公网IP，url需要以纯文本返回IP
+func detectPublicIp(url string) (string, error) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(url)
+	if err != nil {
+		return "", fmt.Errorf("查询公网IP失败:%s", err.Error())
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("查询公网IP失败，%s返回的状态码为%d", url, resp.StatusCode)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("查询公网IP失败:%s", err.Error())
+	}
+
+	ip := strings.TrimSpace(string(body))
+	if net.ParseIP(ip) == nil {
+		return "", fmt.Errorf("%s返回的内容不是合法的IP", url)
+	}
+	return ip, nil
+}
+
+// allowMeCidr 把单个IP转换为只包含该IP的网段
+func allowMeCidr(ip string) (string, error) {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return "", fmt.Errorf("%s不是合法的IP", ip)
+	}
+	if parsed.To4() != nil {
+		return parsed.String() + "/32", nil
+	}
+	return parsed.String() + "/128", nil
+}
+
+// allowMeDescription 生成带有过期时间的规则描述，note过长时会被截断以满足描述的长度限制
+func allowMeDescription(note string, expireAt time.Time) string {
+	marker := allowMeMarker + expireAt.UTC().Format(allowMeTimeLayout)
+	if note == "" {
+		return marker
+	}
+	noteRunes := []rune(note)
+	max := driver.MaxFirewallRuleDescriptionLength - len([]rune(marker)) - 1
+	if len(noteRunes) > max {
+		noteRunes = noteRunes[0:max]
+	}
+	return string(noteRunes) + " " + marker
+}
+
+// allowMeExpireAt 从规则描述中解析过期时间，不是allow-me添加的规则返回false
+func allowMeExpireAt(rule *driver.FirewallRule) (time.Time, bool) {
+	index := strings.LastIndex(rule.Description, allowMeMarker)
+	if index < 0 {
+		return time.Time{}, false
+	}
+	expireAt, err := time.Parse(allowMeTimeLayout, rule.Description[index+len(allowMeMarker):])
+	if err != nil {
+		return time.Time{}, false
+	}
+	return expireAt, true
+}
+
+func AllowMeFirewallRule() error {
+
+	var port string
+	var protocol string
+	var ip string
+	var detectURL string
+	var ttl time.Duration
+	var note string
+
+	flag.StringVar(&port, "port", "", "需要开放的端口，写法和防火墙规则中的端口相同，例如22或者8080-8090")
+	flag.StringVar(&protocol, "protocol", "TCP", "协议，可选值为TCP、UDP")
+	flag.StringVar(&ip, "ip", "", "允许访问的IP，不填则自动查询本机的公网IP")
+	flag.StringVar(&detectURL, "detect-url", "", "查询本机公网IP的地址，不填则使用配置项ip-detect-url，未配置则为"+config.DefaultIpDetectURL)
+	flag.DurationVar(&ttl, "ttl", 8*time.Hour, "规则的有效期，例如30m、2h，过期后可以通过revoke-expired删除")
+	flag.StringVar(&note, "desc", "", "规则描述，会和过期时间一起写入规则的描述中")
+
+	var rule *driver.FirewallRule
+
+	return batchOperatorInstances("添加临时防火墙规则", true, func(region string, insids string) error {
+		checkArg(&port, "端口不能为空")
+		if ttl <= 0 {
+			return errors.New("有效期必须大于0")
+		}
+
+		if ip == "" {
+			if detectURL == "" {
+				detectURL = config.ActiveProfile().IpDetectURL
+			}
+			var err error
+			ip, err = detectPublicIp(detectURL)
+			if err != nil {
+				return err
+			}
+		}
+
+		cidr, err := allowMeCidr(ip)
+		if err != nil {
+			return err
+		}
+
+		expireAt := time.Now().Add(ttl)
+		rule = &driver.FirewallRule{
+			Protocol:    driver.FirewallRuleProtocol(protocol),
+			Port:        port,
+			CidrBlock:   cidr,
+			Action:      driver.FirewallRuleAction("ACCEPT"),
+			Description: allowMeDescription(note, expireAt),
+		}
+		if err := driver.NormalizeFirewallRule(rule); err != nil {
+			return err
+		}
+		if rule.Protocol != "TCP" && rule.Protocol != "UDP" {
+			return errors.New("协议只能为TCP或者UDP")
+		}
+
+		fmt.Printf("将允许%s访问%s端口%s，有效期至%s\n", cidr, rule.Protocol, rule.Port, expireAt.Format("2006-01-02 15:04:05"))
+		planRules("添加", []*driver.FirewallRule{rule})
+		return nil
+	}, func(cdriver driver.Driver, region, name, insid string, args ...interface{}) error {
+		current, err := cdriver.ListFirewallRules(region, insid)
+		if err != nil {
+			return err
+		}
+
+		// 已经有相同的永久规则时不做修改，否则会被替换为临时规则，过期后被revoke-expired删除
+		key := driver.FirewallRuleKey(rule)
+		refresh := []*driver.FirewallRule{}
+		for _, exist := range current {
+			if driver.FirewallRuleKey(exist) != key {
+				continue
+			}
+			if _, ok := allowMeExpireAt(exist); !ok {
+				return fmt.Errorf("已经存在允许%s访问%s端口%s的永久规则，不会替换为临时规则", rule.CidrBlock, rule.Protocol, rule.Port)
+			}
+			refresh = append(refresh, exist)
+		}
+
+		if len(refresh) == 0 {
+			return cdriver.AddFirewallRules(region, insid, []*driver.FirewallRule{rule})
+		}
+
+		// 之前通过allow-me添加过相同的规则时只修改描述来刷新过期时间，规则本身一直存在，不会中断访问。
+		// 修改成功后再删除多余的重复规则
+		if err := cdriver.ModifyFirewallRuleDescription(region, insid, rule); err != nil {
+			return err
+		}
+		if len(refresh) > 1 {
+			return cdriver.DeleteFirewallRules(region, insid, refresh[1:])
+		}
+		return nil
+	})
+}
+
+func RevokeExpiredFirewallRules() error {
+
+	var all bool
+	flag.BoolVar(&all, "all", false, "删除所有通过allow-me添加的规则，不管是否过期")
+
+	return batchOperatorInstances("删除过期的临时防火墙规则", false, func(region string, insids string) error {
+		return nil
+	}, func(cdriver driver.Driver, region, name, insid string, args ...interface{}) error {
+		current, err := cdriver.ListFirewallRules(region, insid)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		expired := []*driver.FirewallRule{}
+		for _, rule := range current {
+			expireAt, ok := allowMeExpireAt(rule)
+			if ok && (all || expireAt.Before(now)) {
+				expired = append(expired, rule)
+			}
+		}
+
+		output := outputOf(args)
+		if len(expired) == 0 {
+			fmt.Fprintf(output, "%s地域下的%s(%s)没有需要删除的临时规则\n", region, name, insid)
+			return nil
+		}
+
+		fmt.Fprintf(output, "%s地域下的%s(%s)将删除以下临时规则:\n", region, name, insid)
+		for _, rule := range expired {
+			fmt.Fprintln(output, "  -", rule.Protocol, "|", rule.Port, "|", rule.CidrBlock, "|", rule.Action, "|", rule.Description)
+		}
+		return cdriver.DeleteFirewallRules(region, insid, expired)
+	})
+}
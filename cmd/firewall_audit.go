@@ -0,0 +1,167 @@
+package cmd
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/lixiaofei123/lhbin/config"
+	"github.com/lixiaofei123/lhbin/driver"
+)
+
+func init() {
+	RegisterChildCommandOperator(FirewallCommandName, "audit", "检查符合条件的实例的防火墙规则中存在风险的配置，按风险等级排序输出", []string{}, SafeOperation(AuditFirewallRules))
+}
+
+// firewallFinding 审计发现的单个风险
+type firewallFinding struct {
+	Level      driver.FirewallRiskLevel
+	Account    string
+	Region     string
+	Instance   string
+	InstanceId string
+	Rule       *driver.FirewallRule
+	Message    string
+}
+
+// firewallAuditError 查询失败的实例，审计结果中单独列出，避免被当成没有风险
+type firewallAuditError struct {
+	Account    string
+	Region     string
+	Instance   string
+	InstanceId string
+	Error      string
+}
+
+type firewallAuditReport struct {
+	Findings []*firewallFinding
+	Errors   []*firewallAuditError
+}
+
+func AuditFirewallRules() error {
+
+	var region string
+	var insids string
+	var output string
+	var minLevel string
+	var failOn string
+
+	cdrivers, err := parseAndGetDrivers(func() {
+		flag.StringVar(&region, "region", "", "实例所在地域，不填则为账户的默认地域，未设置默认地域或者填写all则为所有地域")
+		flag.StringVar(&insids, "insids", "", "实例ID，多个请用逗号隔开。如果不填则默认为所选择地域下的所有实例")
+		flag.StringVar(&minLevel, "level", "low", "只输出不低于此等级的风险，可选值为high、medium、low")
+		flag.StringVar(&failOn, "fail-on", "", "存在不低于此等级的风险时以失败状态退出，方便在定时任务中使用，可选值为high、medium、low")
+		outputFlag(&output)
+	}, func() error {
+		if parseRiskLevel(minLevel).Rank() == 0 {
+			return fmt.Errorf("风险等级%s无效", minLevel)
+		}
+		if failOn != "" && parseRiskLevel(failOn).Rank() == 0 {
+			return fmt.Errorf("风险等级%s无效", failOn)
+		}
+		return nil
+	}, os.Args[3:])
+
+	if err != nil {
+		return err
+	}
+
+	report := &firewallAuditReport{Findings: []*firewallFinding{}, Errors: []*firewallAuditError{}}
+	for _, cdriver := range cdrivers {
+		targets, err := collectInstances(cdriver, insids)
+		if err != nil {
+			// 多账户时单个账户查询失败只记录到审计结果中，继续审计其它账户
+			if !multiAccountMode {
+				return err
+			}
+			report.Errors = append(report.Errors, &firewallAuditError{Account: cdriver.account, Region: cdriver.region, Error: err.Error()})
+			continue
+		}
+
+		for _, target := range targets {
+			rules, err := target.cdriver.ListFirewallRules(target.region, target.insid)
+			if err != nil {
+				report.Errors = append(report.Errors, &firewallAuditError{
+					Account: target.account, Region: target.region, Instance: target.name, InstanceId: target.insid, Error: err.Error(),
+				})
+				continue
+			}
+
+			for _, rule := range rules {
+				for _, risk := range driver.AuditFirewallRule(rule) {
+					if risk.Level.Rank() < parseRiskLevel(minLevel).Rank() {
+						continue
+					}
+					report.Findings = append(report.Findings, &firewallFinding{
+						Level: risk.Level, Account: target.account, Region: target.region, Instance: target.name, InstanceId: target.insid, Rule: rule, Message: risk.Message,
+					})
+				}
+			}
+		}
+	}
+
+	sort.SliceStable(report.Findings, func(i, j int) bool {
+		return report.Findings[i].Level.Rank() > report.Findings[j].Level.Rank()
+	})
+
+	if output == string(config.JsonOutput) {
+		if err := printJson(report); err != nil {
+			return err
+		}
+	} else {
+		printFirewallAuditReport(report)
+	}
+
+	if failOn != "" {
+		for _, finding := range report.Findings {
+			if finding.Level.Rank() >= parseRiskLevel(failOn).Rank() {
+				return fmt.Errorf("存在%s及以上等级的防火墙风险", parseRiskLevel(failOn))
+			}
+		}
+	}
+	if len(report.Errors) > 0 {
+		return fmt.Errorf("有%d个实例或账户的防火墙规则查询失败", len(report.Errors))
+	}
+
+	return nil
+}
+
+func parseRiskLevel(level string) driver.FirewallRiskLevel {
+	switch level {
+	case "high", "HIGH":
+		return driver.FirewallRiskHigh
+	case "medium", "MEDIUM":
+		return driver.FirewallRiskMedium
+	case "low", "LOW":
+		return driver.FirewallRiskLow
+	}
+	return driver.FirewallRiskLevel(level)
+}
+
+func printFirewallAuditReport(report *firewallAuditReport) {
+	fmt.Println("------------------------------------------")
+	fmt.Println(accountHeader() + "| 等级 | 地域 | 实例名称 | 实例ID | 规则 | 问题 |")
+	fmt.Println("------------------------------------------")
+
+	for _, finding := range report.Findings {
+		rule := finding.Rule
+		fmt.Print(accountColumn(finding.Account))
+		fmt.Println("|", finding.Level, "|", finding.Region, "|", finding.Instance, "|", finding.InstanceId, "|", rule.Protocol, rule.Port, rule.CidrBlock, rule.Action, "|", finding.Message, "|")
+		fmt.Println("------------------------------------------")
+	}
+
+	for _, auditErr := range report.Errors {
+		if auditErr.InstanceId == "" {
+			fmt.Printf("查询账户%s的实例失败，原因是:%s \n", auditErr.Account, auditErr.Error)
+			continue
+		}
+		fmt.Printf("%s地域下的%s(%s)防火墙规则查询失败，原因是:%s \n", auditErr.Region, auditErr.Instance, auditErr.InstanceId, auditErr.Error)
+	}
+
+	counts := map[driver.FirewallRiskLevel]int{}
+	for _, finding := range report.Findings {
+		counts[finding.Level]++
+	}
+	fmt.Printf("共发现%d个风险，其中HIGH %d个，MEDIUM %d个，LOW %d个\n", len(report.Findings), counts[driver.FirewallRiskHigh], counts[driver.FirewallRiskMedium], counts[driver.FirewallRiskLow])
+}
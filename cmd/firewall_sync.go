@@ -0,0 +1,202 @@
+package cmd
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/lixiaofei123/lhbin/driver"
+)
+
+func init() {
+	RegisterChildCommandOperator(FirewallCommandName, "export", "导出指定实例的防火墙规则，输出的文件可以直接用于apply操作", []string{}, SafeOperation(ExportFirewallRules))
+	RegisterChildCommandOperator(FirewallCommandName, "drift", "以指定实例的防火墙规则为基准，列出其它实例和基准之间的差异", []string{}, SafeOperation(DriftFirewallRules))
+	RegisterChildCommandOperator(FirewallCommandName, "sync", "以指定实例的防火墙规则为基准，同步到符合条件的其它实例上", []string{}, SafeOperation(SyncFirewallRules))
+}
+
+func ExportFirewallRules() error {
+
+	var region string
+	var insid string
+	var format string
+
+	cdriver, err := parseAndGetDriver(func() {
+		flag.StringVar(&region, "region", "", "实例所在地域")
+		flag.StringVar(&insid, "insid", "", "实例ID")
+		flag.StringVar(&format, "format", "yaml", "导出格式，可选值为yaml、json")
+	}, func() error {
+		checkArg(&region, "地域不能为空")
+		checkArg(&insid, "实例ID不能为空")
+		return nil
+	}, os.Args[3:])
+
+	if err != nil {
+		return err
+	}
+
+	rules, err := cdriver.ListFirewallRules(region, insid)
+	if err != nil {
+		return err
+	}
+
+	if format == "json" {
+		return printJson(rules)
+	}
+	return printYaml(rules)
+}
+
+// firewallDrift 单个实例和基准规则之间的差异
+type firewallDrift struct {
+	target *instanceTarget
+	diff   *driver.FirewallRuleDiff
+	err    error
+}
+
+// compareFirewallRules 读取基准实例的规则，并和符合过滤条件的其它实例逐一比较
+func compareFirewallRules() (driver.Driver, []*firewallDrift, error) {
+
+	var region string
+	var from string
+	var fromRegion string
+	var toFilter string
+
+	cdriver, err := parseAndGetDriver(func() {
+		flag.StringVar(&region, "region", "", "目标实例所在地域，不填则为账户的默认地域，未设置默认地域或者填写all则为所有地域")
+		flag.StringVar(&from, "from", "", "作为基准的实例ID")
+		flag.StringVar(&fromRegion, "from-region", "", "基准实例所在地域，不填则和region参数相同")
+		flag.StringVar(&toFilter, "to-filter", "", "目标实例过滤条件，格式为key=pattern，多个条件用逗号隔开，key可选值为name、id、region，pattern支持*和?通配符，例如name=web-*")
+	}, func() error {
+		checkArg(&from, "基准实例ID不能为空")
+		checkArg(&toFilter, "目标实例过滤条件不能为空")
+		return nil
+	}, os.Args[3:])
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if fromRegion == "" {
+		fromRegion = region
+	}
+	if fromRegion == "" {
+		return nil, nil, fmt.Errorf("未设置地域时需要通过from-region参数指定基准实例所在地域")
+	}
+
+	filter, err := parseInstanceFilter(toFilter)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	baseline, err := cdriver.ListFirewallRules(fromRegion, from)
+	if err != nil {
+		return nil, nil, fmt.Errorf("查询基准实例%s的防火墙规则失败:%s", from, err.Error())
+	}
+
+	targets, err := collectInstances(&accountDriver{driver: cdriver, region: region}, "")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	drifts := []*firewallDrift{}
+	for _, target := range targets {
+		if target.insid == from || !filter.match(target) {
+			continue
+		}
+
+		drift := &firewallDrift{target: target}
+		current, err := cdriver.ListFirewallRules(target.region, target.insid)
+		if err != nil {
+			drift.err = err
+		} else {
+			drift.diff = driver.DiffFirewallRules(current, baseline)
+		}
+		drifts = append(drifts, drift)
+	}
+
+	return cdriver, drifts, nil
+}
+
+func printFirewallDrifts(drifts []*firewallDrift) {
+	fmt.Println("------------------------------------------")
+	fmt.Println("| 地域 | 实例名称 | 实例ID | 状态 | 需新增 | 需删除 |")
+	fmt.Println("------------------------------------------")
+
+	for _, drift := range drifts {
+		target := drift.target
+		if drift.err != nil {
+			fmt.Println("|", target.region, "|", target.name, "|", target.insid, "| 查询失败:", drift.err.Error(), "|")
+		} else if drift.diff.Empty() {
+			fmt.Println("|", target.region, "|", target.name, "|", target.insid, "| 一致 | 0 | 0 |")
+		} else {
+			fmt.Println("|", target.region, "|", target.name, "|", target.insid, "| 有差异 |", len(drift.diff.Add), "|", len(drift.diff.Remove), "|")
+		}
+		fmt.Println("------------------------------------------")
+	}
+
+	for _, drift := range drifts {
+		if drift.err == nil && !drift.diff.Empty() {
+			fmt.Printf("%s地域下的%s(%s)和基准的差异(+需新增 -需删除):\n", drift.target.region, drift.target.name, drift.target.insid)
+			printFirewallRuleDiff(&driver.FirewallRuleDiff{Add: drift.diff.Add, Remove: drift.diff.Remove})
+		}
+	}
+}
+
+func DriftFirewallRules() error {
+
+	_, drifts, err := compareFirewallRules()
+	if err != nil {
+		return err
+	}
+
+	printFirewallDrifts(drifts)
+	return nil
+}
+
+func SyncFirewallRules() error {
+
+	cdriver, drifts, err := compareFirewallRules()
+	if err != nil {
+		return err
+	}
+
+	printFirewallDrifts(drifts)
+
+	changed := 0
+	for _, drift := range drifts {
+		if drift.err == nil && !drift.diff.Empty() {
+			changed++
+		}
+	}
+	if changed == 0 {
+		return nil
+	}
+	if !confirmRisk(fmt.Sprintf("将同步%d个实例的防火墙规则，目标实例上基准中没有的规则会被删除", changed)) {
+		return nil
+	}
+
+	failed := []string{}
+	for _, drift := range drifts {
+		if drift.err != nil || drift.diff.Empty() {
+			continue
+		}
+
+		target := drift.target
+		if planned("将对%s地域下的%s(%s)新增%d条规则，删除%d条规则", target.region, target.name, target.insid, len(drift.diff.Add), len(drift.diff.Remove)) {
+			continue
+		}
+
+		err := applyFirewallRuleDiff(cdriver, target.region, target.insid, drift.diff)
+		if err != nil {
+			fmt.Printf("%s地域下的%s(%s)防火墙规则同步失败，原因是:%s \n", target.region, target.name, target.insid, err.Error())
+			failed = append(failed, target.insid)
+		} else {
+			fmt.Printf("%s地域下的%s(%s)防火墙规则同步成功 \n", target.region, target.name, target.insid)
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("以下实例同步失败:%s", strings.Join(failed, ","))
+	}
+	return nil
+}
@@ -0,0 +1,258 @@
+package cmd
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/lixiaofei123/lhbin/config"
+	"github.com/lixiaofei123/lhbin/driver"
+)
+
+const FirewallTemplateCommandName string = "firewall-template"
+
+func init() {
+
+	RegisterChildCommand(FirewallTemplateCommandName, "管理本地保存的防火墙规则模板，也可以通过 lhbin firewall template 调用", []string{"fwt"})
+	RegisterChildCommandOperator(FirewallTemplateCommandName, "list", "列出所有防火墙模板", []string{}, SafeOperation(ListFirewallTemplates))
+	RegisterChildCommandOperator(FirewallTemplateCommandName, "show", "查看防火墙模板中的规则", []string{"desc"}, SafeOperation(ShowFirewallTemplate))
+	RegisterChildCommandOperator(FirewallTemplateCommandName, "add", "从规则文件创建防火墙模板，同名模板会被覆盖", []string{"create"}, SafeOperation(AddFirewallTemplate))
+	RegisterChildCommandOperator(FirewallTemplateCommandName, "capture", "将指定实例当前的防火墙规则保存为模板，同名模板会被覆盖", []string{}, SafeOperation(CaptureFirewallTemplate))
+	RegisterChildCommandOperator(FirewallTemplateCommandName, "del", "删除防火墙模板", []string{"delete"}, RiskOperation("", DeleteFirewallTemplate))
+	RegisterChildCommandOperator(FirewallTemplateCommandName, "import", "将轻量服务器的默认防火墙规则模板导入为本地模板", []string{}, SafeOperation(ImportFirewallTemplate))
+}
+
+func templateRulesToFirewallRules(template *config.FirewallTemplate) []*driver.FirewallRule {
+	rules := []*driver.FirewallRule{}
+	for _, rule := range template.Rules {
+		rules = append(rules, &driver.FirewallRule{
+			Protocol:    driver.FirewallRuleProtocol(rule.Protocol),
+			Port:        rule.Port,
+			CidrBlock:   rule.CidrBlock,
+			Action:      driver.FirewallRuleAction(rule.Action),
+			Description: rule.Description,
+		})
+	}
+	return rules
+}
+
+func firewallRulesToTemplate(name, description string, rules []*driver.FirewallRule) *config.FirewallTemplate {
+	template := &config.FirewallTemplate{
+		Name:        name,
+		Description: description,
+		Rules:       []*config.FirewallTemplateRule{},
+	}
+	for _, rule := range rules {
+		template.Rules = append(template.Rules, &config.FirewallTemplateRule{
+			Protocol:    string(rule.Protocol),
+			Port:        rule.Port,
+			CidrBlock:   rule.CidrBlock,
+			Action:      string(rule.Action),
+			Description: rule.Description,
+		})
+	}
+	return template
+}
+
+// loadTemplateRules 按顺序合并多个模板中的规则，重复的规则只保留第一次出现的
+func loadTemplateRules(names string) ([]*driver.FirewallRule, error) {
+	rules := []*driver.FirewallRule{}
+	seen := map[string]bool{}
+	for _, name := range strings.Split(names, ",") {
+		template, err := config.FindFirewallTemplate(strings.TrimSpace(name))
+		if err != nil {
+			return nil, err
+		}
+		for _, rule := range templateRulesToFirewallRules(template) {
+			key := driver.FirewallRuleKey(rule)
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			rules = append(rules, rule)
+		}
+	}
+	return rules, nil
+}
+
+func ListFirewallTemplates() error {
+
+	templates, err := config.ListFirewallTemplates()
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("------------------------------------------")
+	fmt.Println("| 模板名称 | 规则数量 | 描述 |")
+	fmt.Println("------------------------------------------")
+	for _, template := range templates {
+		fmt.Println("|", template.Name, "|", len(template.Rules), "|", template.Description, "|")
+		fmt.Println("------------------------------------------")
+	}
+
+	return nil
+}
+
+func ShowFirewallTemplate() error {
+
+	var name string
+	var format string
+
+	flag.StringVar(&name, "name", "", "模板名称，多个模板用逗号隔开，会显示合并后的规则")
+	flag.StringVar(&format, "format", "table", "输出格式，可选值为table、yaml、json，yaml格式可以直接用于firewall apply")
+	flag.CommandLine.Parse(os.Args[3:])
+
+	checkArg(&name, "模板名称不能为空")
+
+	rules, err := loadTemplateRules(name)
+	if err != nil {
+		return err
+	}
+
+	switch format {
+	case "yaml":
+		return printYaml(rules)
+	case "json":
+		return printJson(rules)
+	}
+
+	fmt.Println("---------------------------------------")
+	fmt.Println("| 协议 | 端口 | 来源 | 策略 | 描述 |")
+	fmt.Println("---------------------------------------")
+	for _, rule := range rules {
+		fmt.Println("|", rule.Protocol, "|", rule.Port, "|", rule.CidrBlock, "|", rule.Action, "|", rule.Description, "|")
+		fmt.Println("---------------------------------------")
+	}
+
+	return nil
+}
+
+func AddFirewallTemplate() error {
+
+	var name string
+	var desc string
+	var file string
+
+	flag.StringVar(&name, "name", "", "模板名称")
+	flag.StringVar(&desc, "desc", "", "模板描述")
+	flag.StringVar(&file, "file", "", "规则文件路径，格式和firewall apply相同")
+	flag.CommandLine.Parse(os.Args[3:])
+
+	checkArg(&name, "模板名称不能为空")
+	checkArg(&file, "规则文件路径不能为空")
+
+	rules, err := loadFirewallRules(file)
+	if err != nil {
+		return err
+	}
+
+	err = config.SaveFirewallTemplate(firewallRulesToTemplate(name, desc, rules))
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("防火墙模板%s保存成功，共%d条规则\n", name, len(rules))
+	return nil
+}
+
+func CaptureFirewallTemplate() error {
+
+	var name string
+	var desc string
+	var region string
+	var insid string
+
+	cdriver, err := parseAndGetDriver(func() {
+		flag.StringVar(&name, "name", "", "模板名称")
+		flag.StringVar(&desc, "desc", "", "模板描述")
+		flag.StringVar(&region, "region", "", "实例所在地域")
+		flag.StringVar(&insid, "insid", "", "实例ID")
+	}, func() error {
+		checkArg(&name, "模板名称不能为空")
+		checkArg(&region, "地域不能为空")
+		checkArg(&insid, "实例ID不能为空")
+		return nil
+	}, os.Args[3:])
+
+	if err != nil {
+		return err
+	}
+
+	rules, err := cdriver.ListFirewallRules(region, insid)
+	if err != nil {
+		return err
+	}
+
+	if err := checkFirewallRules(rules); err != nil {
+		return err
+	}
+
+	err = config.SaveFirewallTemplate(firewallRulesToTemplate(name, desc, rules))
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("已将%s的防火墙规则保存为模板%s，共%d条规则\n", insid, name, len(rules))
+	return nil
+}
+
+func DeleteFirewallTemplate() error {
+
+	var name string
+
+	flag.StringVar(&name, "name", "", "模板名称")
+	flag.CommandLine.Parse(os.Args[3:])
+
+	checkArg(&name, "模板名称不能为空")
+
+	if planned("将删除防火墙模板%s", name) {
+		return nil
+	}
+
+	err := config.DeleteFirewallTemplate(name)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("防火墙模板%s删除成功\n", name)
+	return nil
+}
+
+func ImportFirewallTemplate() error {
+
+	var name string
+	var region string
+
+	cdriver, err := parseAndGetDriver(func() {
+		flag.StringVar(&name, "name", "lighthouse-default", "保存的模板名称")
+		flag.StringVar(&region, "region", "", "地域，不填则为ap-guangzhou")
+	}, func() error {
+		return nil
+	}, os.Args[3:])
+
+	if err != nil {
+		return err
+	}
+
+	if region == "" {
+		region = "ap-guangzhou"
+	}
+
+	rules, err := cdriver.FirewallRulesTemplate(region)
+	if err != nil {
+		return err
+	}
+
+	if err := checkFirewallRules(rules); err != nil {
+		return err
+	}
+
+	err = config.SaveFirewallTemplate(firewallRulesToTemplate(name, "轻量服务器默认防火墙规则模板", rules))
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("已将轻量服务器默认防火墙规则模板导入为%s，共%d条规则\n", name, len(rules))
+	return nil
+}
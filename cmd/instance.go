@@ -1,13 +1,19 @@
 package cmd
 
 import (
+	"bytes"
+	"errors"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"os"
 	"strings"
+	"sync"
 
+	"github.com/lixiaofei123/lhbin/config"
 	"github.com/lixiaofei123/lhbin/driver"
+	"gopkg.in/yaml.v2"
 )
 
 const InstanceCommandName string = "ins"
@@ -21,18 +27,92 @@ func init() {
 	RegisterChildCommandOperator(InstanceCommandName, "start", "启动指定条件的轻量实例", []string{}, SafeOperation(StartInstances))
 	RegisterChildCommandOperator(InstanceCommandName, "restart", "重启指定条件的轻量实例", []string{"reboot"}, RiskOperation("请确认已经保存好相关的工作", RebootInstances))
 	RegisterChildCommandOperator(InstanceCommandName, "passwd", "修改指定条件的轻量实例的密码", []string{""}, RiskOperation("修改过程中会重启服务器，请确认已经保存好相关的工作", ResetInstancesPassword))
-	RegisterChildCommandOperator(InstanceCommandName, "reset", "重置指定条件的轻量服务器的镜像", []string{}, DangerOperation("重置服务器后无法恢复，请注意备份好相关数据", ResetInstances))
+	RegisterChildCommandOperator(InstanceCommandName, "reset", "重置指定条件的轻量服务器的镜像", []string{}, SafeOperation(ResetInstances))
+	RegisterChildCommandOperator(InstanceCommandName, "reset-check", "检查指定条件的轻量服务器能否重置为指定镜像", []string{}, SafeOperation(CheckResetInstances))
 	//RegisterChildCommandOperator(InstanceCommandName, "terminate", "销毁指定条件的轻量实例", []string{"destory"}, DangerOperation("销毁服务器后无法恢复，请注意备份好相关数据。是否退款以腾讯云官方为准。", TerminateInstances))
 }
 
+// resolveRegions 地域为空时返回所有地域，否则只返回指定地域
+func resolveRegions(cdriver driver.Driver, region string) ([]string, error) {
+	if region != "" {
+		return []string{region}, nil
+	}
+
+	lhregions, err := cdriver.ListRegions()
+	if err != nil {
+		return nil, err
+	}
+	regions := []string{}
+	for _, lhregion := range lhregions {
+		regions = append(regions, lhregion.Region)
+	}
+	return regions, nil
+}
+
+type instanceTarget struct {
+	account string
+	cdriver driver.Driver
+	region  string
+	name    string
+	insid   string
+}
+
+// collectInstances 根据地域和实例ID列表找出需要操作的实例，地域为空表示所有地域，实例ID为空表示地域下的所有实例
+func collectInstances(cdriver *accountDriver, insids string) ([]*instanceTarget, error) {
+	return collectInstancesTo(cdriver, insids, stdoutLog)
+}
+
+// collectInstancesTo 和collectInstances相同，查询失败的实例通过logf输出
+func collectInstancesTo(cdriver *accountDriver, insids string, logf logFunc) ([]*instanceTarget, error) {
+
+	regions, err := resolveRegions(cdriver.driver, cdriver.region)
+	if err != nil {
+		return nil, err
+	}
+
+	targets := []*instanceTarget{}
+	for _, region := range regions {
+		if insids == "" {
+			inss, err := cdriver.driver.ListInstances(region)
+			if err != nil {
+				return nil, err
+			}
+			for _, ins := range inss {
+				targets = append(targets, &instanceTarget{account: cdriver.account, cdriver: cdriver.driver, region: region, name: ins.Name, insid: ins.ID})
+			}
+		} else {
+			instanceIDs := strings.Split(insids, ",")
+			for _, instanceID := range instanceIDs {
+				insinfo, err := cdriver.driver.InstanceInfo(region, instanceID)
+				if err != nil {
+					if multiAccountMode {
+						logf("查询账户%s在%s地域下的%s信息失败，原因是:%s \n", cdriver.account, region, instanceID, err.Error())
+					} else {
+						logf("查询%s地域下的%s信息失败 \n", region, instanceID)
+					}
+				} else {
+					targets = append(targets, &instanceTarget{account: cdriver.account, cdriver: cdriver.driver, region: region, name: insinfo.Name, insid: instanceID})
+				}
+			}
+		}
+	}
+
+	return targets, nil
+}
+
 func baseBatchOperatorInstances(secondConfirm bool, checkCallback func(region string, insids string) error, callback func(cdriver driver.Driver, region, name string, insid string, args ...interface{})) error {
+	return runBatchOperatorInstances(secondConfirm, 1, checkCallback, callback)
+}
+
+// runBatchOperatorInstances 找出符合条件的实例并依次调用callback，callback的第一个额外参数为实例所属的账户名称
+func runBatchOperatorInstances(secondConfirm bool, parallelism int, checkCallback func(region string, insids string) error, callback func(cdriver driver.Driver, region, name string, insid string, args ...interface{})) error {
 
 	var region string
 	var insids string
 	var insid string
 	var force bool
-	cdriver, err := parseAndGetDriver(func() {
-		flag.StringVar(&region, "region", "", "实例所在地域，不填则默认为所有可用区")
+	cdrivers, err := parseAndGetDrivers(func() {
+		flag.StringVar(&region, "region", "", "实例所在地域，不填则为账户的默认地域，未设置默认地域或者填写all则为所有地域")
 		flag.StringVar(&insid, "insid", "", "实例ID，如果设置此值，则会忽略insids参数")
 		flag.StringVar(&insids, "insids", "", "实例ID，多个请用逗号隔开。如果不填则默认为所选择可用区下的所有实例")
 		flag.BoolVar(&force, "f", false, "强制执行，忽略二次确认")
@@ -52,10 +132,19 @@ func baseBatchOperatorInstances(secondConfirm bool, checkCallback func(region st
 		return err
 	}
 
-	if secondConfirm && !force {
+	if secondConfirm && !force && !dryRun && config.ActiveProfile().Confirm != config.ConfirmNone {
 
 		var needConfirm = false
 
+		if multiAccountMode {
+			accounts := []string{}
+			for _, cdriver := range cdrivers {
+				accounts = append(accounts, cdriver.account)
+			}
+			fmt.Printf("操作将对账户%s生效\n", strings.Join(accounts, ","))
+			needConfirm = true
+		}
+
 		if region == "" && insids == "" {
 			fmt.Println("未设置地域和实例ID，操作将对所有的实例生效")
 			needConfirm = true
@@ -70,7 +159,7 @@ func baseBatchOperatorInstances(secondConfirm bool, checkCallback func(region st
 			fmt.Println("如果不希望出现此确认步骤，请加上-f参数来强制运行")
 			fmt.Print("请输入Y来确认是否进行下一步操作（不区分大小写，输入其他任意字符取消操作）:")
 			var confirm string
-			fmt.Scan(&confirm)
+			confirm = readLine()
 			fmt.Println("")
 			if strings.ToLower(confirm) != "y" {
 				log.Println("操作已取消")
@@ -80,61 +169,44 @@ func baseBatchOperatorInstances(secondConfirm bool, checkCallback func(region st
 
 	}
 
-	if region == "" {
-		regions, err := cdriver.ListRegions()
+	targets := []*instanceTarget{}
+	for _, cdriver := range cdrivers {
+		accountTargets, err := collectInstances(cdriver, insids)
 		if err != nil {
-			log.Panic(err)
-		}
-
-		for _, region := range regions {
-			if insids == "" {
-				inss, err := cdriver.ListInstances(region.Region)
-				if err != nil {
-					log.Fatal(err)
-				}
-				for _, ins := range inss {
-					callback(cdriver, region.Region, ins.Name, ins.ID)
-
-				}
-			} else {
-				instanceIDs := strings.Split(insids, ",")
-
-				for _, instanceID := range instanceIDs {
-					insinfo, err := cdriver.InstanceInfo(region.Region, instanceID)
-					if err != nil {
-						fmt.Printf("查询%s地域下的%s信息失败 \n", region.Region, instanceID)
-					} else {
-						callback(cdriver, region.Region, insinfo.Name, instanceID)
-					}
-
-				}
+			if !multiAccountMode {
+				return err
 			}
+			fmt.Printf("查询账户%s下的实例失败，原因是:%s \n", cdriver.account, err.Error())
+			continue
 		}
-	} else {
-
-		if insids == "" {
-			inss, err := cdriver.ListInstances(region)
-			if err != nil {
-				log.Fatal(err)
-			}
-			for _, ins := range inss {
-				callback(cdriver, region, ins.Name, ins.ID)
-
-			}
-		} else {
-			instanceIDs := strings.Split(insids, ",")
-			for _, instanceID := range instanceIDs {
-				insinfo, err := cdriver.InstanceInfo(region, instanceID)
-				if err != nil {
-					fmt.Printf("查询%s地域下的%s信息失败 \n", region, instanceID)
-				} else {
-					callback(cdriver, region, insinfo.Name, instanceID)
-				}
+		targets = append(targets, accountTargets...)
+	}
 
-			}
+	if parallelism <= 1 {
+		for _, target := range targets {
+			callback(target.cdriver, target.region, target.name, target.insid, target.account)
 		}
+		return nil
+	}
 
+	// 并发执行时每个实例的输出先写入缓冲区，执行结束后再整体输出，避免多个实例的输出交错
+	var wg sync.WaitGroup
+	var outputMutex sync.Mutex
+	limit := make(chan struct{}, parallelism)
+	for _, target := range targets {
+		wg.Add(1)
+		limit <- struct{}{}
+		go func(target *instanceTarget) {
+			defer wg.Done()
+			defer func() { <-limit }()
+			var output bytes.Buffer
+			callback(target.cdriver, target.region, target.name, target.insid, target.account, &output)
+			outputMutex.Lock()
+			os.Stdout.Write(output.Bytes())
+			outputMutex.Unlock()
+		}(target)
 	}
+	wg.Wait()
 
 	return nil
 
@@ -142,63 +214,84 @@ func baseBatchOperatorInstances(secondConfirm bool, checkCallback func(region st
 
 func batchOperatorInstances(operator string, secondConfirm bool, checkCallback func(region string, insids string) error, callback func(cdriver driver.Driver, region, name string, insid string, args ...interface{}) error) error {
 
-	return baseBatchOperatorInstances(secondConfirm, checkCallback, func(cdriver driver.Driver, region, name string, insid string, args ...interface{}) {
+	return runBatchOperatorInstances(secondConfirm, config.ActiveProfile().Parallelism, checkCallback, func(cdriver driver.Driver, region, name string, insid string, args ...interface{}) {
+
+		prefix := ""
+		if multiAccountMode {
+			prefix = "账户" + accountOf(args)
+		}
+
+		if planned("将对%s%s地域的实例%s(%s)执行%s", prefix, region, name, insid, operator) {
+			return
+		}
 
 		err := callback(cdriver, region, name, insid, args...)
 		if err != nil {
-			fmt.Printf("%s地域的实例%s(%s)%s失败，原因是:%s \n", region, name, insid, operator, err.Error())
+			fmt.Fprintf(outputOf(args), "%s%s地域的实例%s(%s)%s失败，原因是:%s \n", prefix, region, name, insid, operator, err.Error())
 		} else {
-			fmt.Printf("%s地域的实例(%s)%s%s成功\n", region, name, insid, operator)
+			fmt.Fprintf(outputOf(args), "%s%s地域的实例(%s)%s%s成功\n", prefix, region, name, insid, operator)
 		}
 	})
 
 }
 
+// accountInstance 多账户模式下JSON输出的实例信息
+type accountInstance struct {
+	Account string
+	*driver.InstanceInfo
+}
+
 func ListInstances() error {
 
 	var region string
+	var output string
 
-	cdriver, err := parseAndGetDriverWithS(func() {
-		flag.StringVar(&region, "region", "", "地域，不填写则默认为所有地域")
-
-	}, os.Args[3:])
+	cdrivers, err := parseAndGetDrivers(func() {
+		flag.StringVar(&region, "region", "", "地域，不填则为账户的默认地域，未设置默认地域或者填写all则为所有地域")
+		outputFlag(&output)
+	}, func() error { return nil }, os.Args[3:])
 
 	if err != nil {
 		return err
 	}
 
-	fmt.Println("------------------------------------------")
-	fmt.Println("| 地域 | 实例名称 | 实例ID | 公网IP | 内网IP | 状态 |")
-	fmt.Println("------------------------------------------")
-
-	if region == "" {
-
-		regions, err := cdriver.ListRegions()
+	instances := []*accountInstance{}
+	for _, cdriver := range cdrivers {
+		regions, err := resolveRegions(cdriver.driver, cdriver.region)
 		if err != nil {
 			return err
 		}
 
 		for _, region := range regions {
-			inss, err := cdriver.ListInstances(region.Region)
+			inss, err := cdriver.driver.ListInstances(region)
 			if err != nil {
 				return err
 			}
 			for _, ins := range inss {
-				fmt.Println("|", region.Region, "|", ins.Name, "|", ins.ID, "|", ins.PublicIP, "|", ins.PrivateIP, "|", ins.State, "|")
-				fmt.Println("------------------------------------------")
+				instances = append(instances, &accountInstance{Account: cdriver.account, InstanceInfo: ins})
 			}
 		}
+	}
 
-	} else {
-		inss, err := cdriver.ListInstances(region)
-		if err != nil {
-			return err
+	if output == string(config.JsonOutput) {
+		if multiAccountMode {
+			return printJson(instances)
 		}
-		for _, ins := range inss {
-			fmt.Println("|", region, "|", ins.Name, "|", ins.ID, "|", ins.PublicIP, "|", ins.PrivateIP, "|", ins.State, "|")
-			fmt.Println("------------------------------------------")
+		inss := []*driver.InstanceInfo{}
+		for _, ins := range instances {
+			inss = append(inss, ins.InstanceInfo)
 		}
+		return printJson(inss)
+	}
 
+	fmt.Println("------------------------------------------")
+	fmt.Println(accountHeader() + "| 地域 | 实例名称 | 实例ID | 公网IP | 内网IP | 状态 |")
+	fmt.Println("------------------------------------------")
+
+	for _, ins := range instances {
+		fmt.Print(accountColumn(ins.Account))
+		fmt.Println("|", ins.Region, "|", ins.Name, "|", ins.ID, "|", ins.PublicIP, "|", ins.PrivateIP, "|", ins.State, "|")
+		fmt.Println("------------------------------------------")
 	}
 
 	fmt.Println("详细信息可以通过 lhbin ins desc --region region --insids lhins-xxxxx,lhins-yyyyy 命令进行查看")
@@ -214,6 +307,9 @@ func DescribeInstances() error {
 		}
 
 		fmt.Println("-------------------------------")
+		if multiAccountMode {
+			fmt.Println("| 账户 | ", accountOf(args), "|")
+		}
 		fmt.Println("| 地域 | ", insinfo.Region, "|")
 		fmt.Println("| ID | ", insinfo.ID, "|")
 		fmt.Println("| 名称 | ", insinfo.Name, "|")
@@ -267,18 +363,195 @@ func TerminateInstances() error {
 
 }
 
+// resetTarget 重置前检查过兼容性的实例，err为查询失败的原因
+type resetTarget struct {
+	target        *instanceTarget
+	compatibility *driver.ResetCompatibility
+	err           error
+}
+
+func (reset *resetTarget) resettable() bool {
+	return reset.err == nil && reset.compatibility.Resettable
+}
+
 func ResetInstances() error {
 
+	var region string
+	var insid string
+	var insids string
+	var blueprintId string
+	var setPassword bool
+	var keyIds string
+	var containersFile string
+	var skipCheck bool
+
+	cdrivers, err := parseAndGetDrivers(func() {
+		flag.StringVar(&region, "region", "", "实例所在地域，不填则为账户的默认地域，未设置默认地域或者填写all则为所有地域")
+		flag.StringVar(&insid, "insid", "", "实例ID，如果设置此值，则会忽略insids参数")
+		flag.StringVar(&insids, "insids", "", "实例ID，多个请用逗号隔开。如果不填则默认为所选择地域下的所有实例")
+		flag.StringVar(&blueprintId, "imageid", "", "镜像ID，可以用过lhbin image list 查询可以使用的镜像")
+		flag.BoolVar(&setPassword, "set-password", false, "设置重置后的登录密码，密码从终端读取，不设置则由系统自动生成")
+		flag.StringVar(&keyIds, "keyids", "", "重置后绑定的密钥ID，多个用逗号隔开")
+		flag.StringVar(&containersFile, "containers", "", "重置后运行的容器配置文件，支持yaml和json格式")
+		flag.BoolVar(&skipCheck, "skip-check", false, "跳过镜像兼容性检查")
+	}, func() error {
+		if insid != "" {
+			insids = insid
+		}
+		checkArg(&blueprintId, "镜像ID不能为空，可以用过lhbin image list 查询可以使用的镜像")
+		return nil
+	}, os.Args[3:])
+
+	if err != nil {
+		return err
+	}
+
+	options := &driver.ResetOptions{}
+	if keyIds != "" {
+		options.KeyIds = strings.Split(keyIds, ",")
+	}
+	if containersFile != "" {
+		containers, err := readContainers(containersFile)
+		if err != nil {
+			return err
+		}
+		options.Containers = containers
+	}
+
+	// 先检查所有实例能否重置并展示检查结果，确认后只重置可以重置的实例
+	resets := []*resetTarget{}
+	for _, cdriver := range cdrivers {
+		targets, err := collectInstances(cdriver, insids)
+		if err != nil {
+			if !multiAccountMode {
+				return err
+			}
+			fmt.Printf("查询账户%s下的实例失败，原因是:%s \n", cdriver.account, err.Error())
+			continue
+		}
+		for _, target := range targets {
+			reset := &resetTarget{target: target, compatibility: &driver.ResetCompatibility{InstanceId: target.insid, Resettable: true}}
+			if !skipCheck {
+				reset.compatibility, reset.err = target.cdriver.ResetCompatibility(target.region, target.insid, blueprintId)
+			}
+			resets = append(resets, reset)
+		}
+	}
+
+	fmt.Println("--------------------------------------")
+	fmt.Println(accountHeader() + "| 地域 | 实例 | 是否可以重置 | 说明 |")
+	fmt.Println("--------------------------------------")
+	resettable := 0
+	for _, reset := range resets {
+		target := reset.target
+		fmt.Print(accountColumn(target.account))
+		if reset.err != nil {
+			fmt.Printf("|%s|%s(%s)|未知|查询失败，原因:%s|\n", target.region, target.name, target.insid, reset.err.Error())
+		} else if reset.compatibility.Resettable {
+			resettable++
+			fmt.Printf("|%s|%s(%s)|是||\n", target.region, target.name, target.insid)
+		} else {
+			fmt.Printf("|%s|%s(%s)|否|%s|\n", target.region, target.name, target.insid, reset.compatibility.Message)
+		}
+		fmt.Println("--------------------------------------")
+	}
+
+	if resettable == 0 {
+		fmt.Printf("没有可以重置为镜像%s的实例\n", blueprintId)
+		return nil
+	}
+
+	if dryRun {
+		for _, reset := range resets {
+			if reset.resettable() {
+				planned("将%s地域的实例%s(%s)重置为镜像%s", reset.target.region, reset.target.name, reset.target.insid, blueprintId)
+			}
+		}
+		return nil
+	}
+
+	if !confirmDanger(fmt.Sprintf("将把以上%d个可以重置的实例重置为镜像%s，重置服务器后无法恢复，请注意备份好相关数据", resettable, blueprintId)) {
+		return nil
+	}
+
+	if setPassword {
+		options.Password = readPassword("请输入重置后的登录密码:")
+		if options.Password == "" {
+			return errors.New("密码不能为空")
+		}
+		if readPassword("请再次输入密码:") != options.Password {
+			return errors.New("两次输入的密码不一致")
+		}
+	}
+
+	failed := []string{}
+	for _, reset := range resets {
+		if !reset.resettable() {
+			continue
+		}
+		target := reset.target
+		prefix := ""
+		if multiAccountMode {
+			prefix = "账户" + target.account
+		}
+		err := target.cdriver.ResetInstances(target.region, []string{target.insid}, blueprintId, options)
+		if err != nil {
+			fmt.Printf("%s%s地域的实例%s(%s)重置镜像失败，原因是:%s \n", prefix, target.region, target.name, target.insid, err.Error())
+			failed = append(failed, target.insid)
+		} else {
+			fmt.Printf("%s%s地域的实例%s(%s)重置镜像成功\n", prefix, target.region, target.name, target.insid)
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("以下实例重置镜像失败:%s", strings.Join(failed, ","))
+	}
+	return nil
+}
+
+func readContainers(path string) ([]*driver.DockerContainer, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	containers := []*driver.DockerContainer{}
+	if err := yaml.Unmarshal(data, &containers); err != nil {
+		return nil, fmt.Errorf("容器配置文件%s格式错误:%s", path, err.Error())
+	}
+
+	for index, container := range containers {
+		if container == nil || container.Image == "" {
+			return nil, fmt.Errorf("容器配置文件%s中第%d个容器没有设置镜像", path, index+1)
+		}
+	}
+
+	return containers, nil
+}
+
+func CheckResetInstances() error {
+
 	var blueprintId string
 	flag.StringVar(&blueprintId, "imageid", "", "镜像ID，可以用过lhbin image list 查询可以使用的镜像")
 
-	return batchOperatorInstances("重置镜像", true, func(region string, insids string) error {
+	return baseBatchOperatorInstances(false, func(region string, insids string) error {
 		checkArg(&blueprintId, "镜像ID不能为空，可以用过lhbin image list 查询可以使用的镜像")
+		fmt.Println("--------------------------------------")
+		fmt.Println(accountHeader() + "| 地域 | 实例 | 是否可以重置 | 说明 |")
+		fmt.Println("--------------------------------------")
 		return nil
-	}, func(cdriver driver.Driver, region, name, insid string, args ...interface{}) error {
-		return cdriver.ResetInstances(region, []string{insid}, blueprintId)
+	}, func(cdriver driver.Driver, region, name, insid string, args ...interface{}) {
+		fmt.Print(accountColumn(accountOf(args)))
+		compatibility, err := cdriver.ResetCompatibility(region, insid, blueprintId)
+		if err != nil {
+			fmt.Printf("|%s|%s(%s)|未知|查询失败，原因:%s|\n", region, name, insid, err.Error())
+		} else if compatibility.Resettable {
+			fmt.Printf("|%s|%s(%s)|是||\n", region, name, insid)
+		} else {
+			fmt.Printf("|%s|%s(%s)|否|%s|\n", region, name, insid, compatibility.Message)
+		}
+		fmt.Println("--------------------------------------")
 	})
-
 }
 
 func ResetInstancesPassword() error {
@@ -0,0 +1,184 @@
+package cmd
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/lixiaofei123/lhbin/driver"
+)
+
+// maxCommandSize 自动化助手要求命令内容经过base64编码后不超过64KB
+const maxCommandSize = 48 * 1024
+
+// invocationPollInterval 查询命令执行结果的间隔
+var invocationPollInterval = 3 * time.Second
+
+func init() {
+	RegisterChildCommandOperator(InstanceCommandName, "run", "通过自动化助手在指定的轻量实例上执行命令，不需要ssh", []string{}, SafeOperation(RunInstancesCommand))
+}
+
+// waitInvocationTasks 等待执行活动在所有实例上结束，超时后返回当前查询到的任务
+func waitInvocationTasks(cdriver driver.Driver, region, invocationID string, count int, timeout time.Duration) ([]*driver.InvocationTask, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		tasks, err := cdriver.InvocationTasks(region, invocationID)
+		if err != nil {
+			return nil, err
+		}
+
+		finished := len(tasks) >= count
+		for _, task := range tasks {
+			if !task.Status.Finished() {
+				finished = false
+			}
+		}
+		if finished || time.Now().After(deadline) {
+			return tasks, nil
+		}
+		time.Sleep(invocationPollInterval)
+	}
+}
+
+func RunInstancesCommand() error {
+
+	var region string
+	var insid string
+	var insids string
+	var script string
+	var content string
+	var commandType string
+	var workdir string
+	var username string
+	var timeout time.Duration
+
+	cdriver, err := parseAndGetDriver(func() {
+		flag.StringVar(&region, "region", "", "实例所在地域")
+		flag.StringVar(&insid, "insid", "", "实例ID，如果设置此值，则会忽略insids参数")
+		flag.StringVar(&insids, "insids", "", "实例ID，多个请用逗号隔开，每次最多100个")
+		flag.StringVar(&script, "script", "", "需要执行的脚本文件路径")
+		flag.StringVar(&content, "command", "", "需要执行的命令，设置script参数时忽略此参数")
+		flag.StringVar(&commandType, "type", "shell", "命令类型，可选值为shell、powershell")
+		flag.StringVar(&workdir, "workdir", "", "命令执行目录，不填则为自动化助手的默认目录")
+		flag.StringVar(&username, "user", "", "执行命令的用户，不填则为root(Linux)或者System(Windows)")
+		flag.DurationVar(&timeout, "timeout", time.Minute, "命令超时时间，最长24小时")
+	}, func() error {
+		if insid != "" {
+			insids = insid
+		}
+		checkArg(&region, "地域不能为空")
+		checkArg(&insids, "实例ID不能为空")
+		if script == "" && content == "" {
+			return fmt.Errorf("脚本文件和命令不能都为空")
+		}
+		if timeout < time.Second || timeout > 24*time.Hour {
+			return fmt.Errorf("命令超时时间需要在1秒到24小时之间")
+		}
+		return nil
+	}, os.Args[3:])
+
+	if err != nil {
+		return err
+	}
+
+	if script != "" {
+		data, err := ioutil.ReadFile(script)
+		if err != nil {
+			return err
+		}
+		content = string(data)
+	}
+	if len(content) > maxCommandSize {
+		return fmt.Errorf("命令内容为%d字节，不能超过%d字节", len(content), maxCommandSize)
+	}
+
+	command := &driver.RemoteCommand{
+		Content:          content,
+		CommandType:      driver.CommandType(strings.ToUpper(commandType)),
+		WorkingDirectory: workdir,
+		Username:         username,
+		Timeout:          int64(timeout / time.Second),
+	}
+	if command.CommandType != driver.ShellCommand && command.CommandType != driver.PowerShellCommand {
+		return fmt.Errorf("不支持的命令类型%s，可选值为shell、powershell", commandType)
+	}
+
+	instanceIDs := strings.Split(insids, ",")
+	if len(instanceIDs) > 100 {
+		return fmt.Errorf("每次最多在100个实例上执行命令")
+	}
+
+	if planned("将通过自动化助手在%s地域的实例%s上执行命令", region, insids) {
+		return nil
+	}
+
+	// 先展示命令内容和实例，确认后再下发
+	fmt.Printf("将通过自动化助手在%s地域的实例%s上执行以下命令:\n", region, insids)
+	fmt.Println(content)
+	if !confirmRisk("命令会在以上实例上执行，请确认命令内容") {
+		return nil
+	}
+
+	invocationID, err := cdriver.RunCommand(region, instanceIDs, command)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("命令已下发，执行活动ID为%s，等待执行结果 \n", invocationID)
+
+	// 多等待一分钟，给命令下发和结果上报留出时间
+	tasks, err := waitInvocationTasks(cdriver, region, invocationID, len(instanceIDs), timeout+time.Minute)
+	if err != nil {
+		return err
+	}
+
+	taskMap := map[string]*driver.InvocationTask{}
+	for _, task := range tasks {
+		taskMap[task.InstanceId] = task
+	}
+
+	for _, instanceID := range instanceIDs {
+		task, ok := taskMap[instanceID]
+		if !ok {
+			continue
+		}
+		fmt.Printf("==================== %s地域的实例%s ====================\n", region, instanceID)
+		if task.Output != "" {
+			fmt.Print(task.Output)
+			if !strings.HasSuffix(task.Output, "\n") {
+				fmt.Println()
+			}
+		}
+		if task.Dropped > 0 {
+			fmt.Printf("输出过长，有%d字节被丢弃\n", task.Dropped)
+		}
+		if task.ErrorInfo != "" {
+			fmt.Println("错误信息:", task.ErrorInfo)
+		}
+	}
+
+	fmt.Println("------------------------------------------")
+	fmt.Println("| 实例ID | 任务ID | 状态 | 退出码 |")
+	fmt.Println("------------------------------------------")
+	failed := 0
+	for _, instanceID := range instanceIDs {
+		task, ok := taskMap[instanceID]
+		if !ok {
+			failed++
+			fmt.Println("|", instanceID, "| | 未查询到任务 | |")
+		} else {
+			if task.Status != driver.TaskSuccess || task.ExitCode != 0 {
+				failed++
+			}
+			fmt.Println("|", instanceID, "|", task.TaskId, "|", task.Status, "|", task.ExitCode, "|")
+		}
+		fmt.Println("------------------------------------------")
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("有%d个实例上的命令没有执行成功", failed)
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lixiaofei123/lhbin/driver"
+)
+
+// fakeInvocationDriver 按调用次数依次返回预设的任务列表，超过预设次数后一直返回最后一个
+type fakeInvocationDriver struct {
+	driver.Driver
+	polls [][]*driver.InvocationTask
+	calls int
+}
+
+func (fake *fakeInvocationDriver) InvocationTasks(region, invocationID string) ([]*driver.InvocationTask, error) {
+	index := fake.calls
+	if index >= len(fake.polls) {
+		index = len(fake.polls) - 1
+	}
+	fake.calls++
+	return fake.polls[index], nil
+}
+
+func invocationTask(instanceID string, status driver.InvocationTaskStatus) *driver.InvocationTask {
+	return &driver.InvocationTask{InstanceId: instanceID, Status: status, ExitCode: -1}
+}
+
+func shortenInvocationPoll(t *testing.T) {
+	interval := invocationPollInterval
+	invocationPollInterval = time.Millisecond
+	t.Cleanup(func() { invocationPollInterval = interval })
+}
+
+func TestWaitInvocationTasksFinished(t *testing.T) {
+	shortenInvocationPoll(t)
+
+	fake := &fakeInvocationDriver{polls: [][]*driver.InvocationTask{
+		// 刚下发时只查询到一个实例的任务
+		{invocationTask("lhins-a", driver.TaskPending)},
+		{invocationTask("lhins-a", driver.TaskRunning), invocationTask("lhins-b", driver.TaskDelivering)},
+		{invocationTask("lhins-a", driver.TaskSuccess), invocationTask("lhins-b", driver.TaskRunning)},
+		{invocationTask("lhins-a", driver.TaskSuccess), invocationTask("lhins-b", driver.TaskFailed)},
+	}}
+
+	tasks, err := waitInvocationTasks(fake, "ap-guangzhou", "inv-test", 2, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fake.calls != 4 {
+		t.Fatalf("查询了%d次，期望为4次", fake.calls)
+	}
+	if len(tasks) != 2 || tasks[0].Status != driver.TaskSuccess || tasks[1].Status != driver.TaskFailed {
+		t.Fatalf("返回的任务不是最后一次查询的结果")
+	}
+}
+
+func TestWaitInvocationTasksTimeout(t *testing.T) {
+	shortenInvocationPoll(t)
+
+	fake := &fakeInvocationDriver{polls: [][]*driver.InvocationTask{
+		{invocationTask("lhins-a", driver.TaskSuccess), invocationTask("lhins-b", driver.TaskRunning)},
+	}}
+
+	start := time.Now()
+	tasks, err := waitInvocationTasks(fake, "ap-guangzhou", "inv-test", 2, 50*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond || elapsed > 5*time.Second {
+		t.Fatalf("等待了%s", elapsed)
+	}
+	if fake.calls < 2 {
+		t.Fatalf("超时前只查询了%d次", fake.calls)
+	}
+	if len(tasks) != 2 || tasks[1].Status != driver.TaskRunning {
+		t.Fatalf("超时后应该返回最后一次查询到的任务")
+	}
+}
@@ -0,0 +1,293 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lixiaofei123/lhbin/config"
+	"golang.org/x/crypto/ssh"
+)
+
+func init() {
+	RegisterChildCommandOperator(InstanceCommandName, "ssh", "通过ssh登录到指定的轻量实例", []string{}, SafeOperation(SSHInstance))
+	RegisterChildCommandOperator(InstanceCommandName, "exec", "通过ssh在符合条件的轻量实例上执行命令，命令写在--之后", []string{}, SafeOperation(ExecInstances))
+}
+
+func sshFlags(options *sshOptions) {
+	flag.StringVar(&options.user, "user", "", "登录用户，不填则根据操作系统推断，Ubuntu为ubuntu，其它为root")
+	flag.StringVar(&options.keyPath, "key", "", "私钥文件路径，不填则使用实例绑定的密钥对在本地登记的私钥文件")
+	flag.IntVar(&options.port, "port", 22, "ssh端口")
+	flag.DurationVar(&options.timeout, "timeout", 10*time.Second, "连接超时时间")
+	flag.BoolVar(&options.acceptNew, "accept-new", false, "第一次连接的主机不再询问，直接记录主机公钥")
+}
+
+func SSHInstance() error {
+
+	var region string
+	var insid string
+	options := &sshOptions{}
+
+	cdriver, err := parseAndGetDriver(func() {
+		flag.StringVar(&region, "region", "", "实例所在地域")
+		flag.StringVar(&insid, "insid", "", "实例ID")
+		sshFlags(options)
+	}, func() error {
+		checkArg(&region, "地域不能为空")
+		checkArg(&insid, "实例ID不能为空")
+		return nil
+	}, os.Args[3:])
+
+	if err != nil {
+		return err
+	}
+
+	host, err := resolveSSHHost(&instanceTarget{cdriver: cdriver, region: region, insid: insid}, options)
+	if err != nil {
+		return err
+	}
+
+	callback, err := hostKeyCallback(options.acceptNew)
+	if err != nil {
+		return err
+	}
+
+	if planned("将以%s用户登录%s地域的实例%s(%s)", host.user, region, insid, host.ip) {
+		return nil
+	}
+
+	client, err := dialSSH(host, options, callback)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	session, err := client.NewSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	session.Stdin = os.Stdin
+	session.Stdout = os.Stdout
+	session.Stderr = os.Stderr
+
+	if isTerminal() {
+		rows, cols := terminalSize()
+		if err := session.RequestPty(os.Getenv("TERM"), rows, cols, ssh.TerminalModes{ssh.ECHO: 1}); err != nil {
+			return err
+		}
+		if restore, err := makeRawTerminal(); err == nil {
+			defer restore()
+		}
+	}
+
+	if err := session.Shell(); err != nil {
+		return err
+	}
+
+	err = session.Wait()
+	var exitErr *ssh.ExitError
+	if errors.As(err, &exitErr) {
+		// 远程shell的退出码只是最后一条命令的结果，不视为lhbin的错误
+		return nil
+	}
+	return err
+}
+
+// execResult 单个实例上命令的执行结果
+type execResult struct {
+	host     *sshHost
+	target   *instanceTarget
+	output   string
+	exitCode int
+	err      error
+}
+
+func runRemoteCommand(host *sshHost, options *sshOptions, callback ssh.HostKeyCallback, command string) (string, int, error) {
+	client, err := dialSSH(host, options, callback)
+	if err != nil {
+		return "", -1, err
+	}
+	defer client.Close()
+
+	session, err := client.NewSession()
+	if err != nil {
+		return "", -1, err
+	}
+	defer session.Close()
+
+	var output bytes.Buffer
+	session.Stdout = &output
+	session.Stderr = &output
+
+	err = session.Run(command)
+	var exitErr *ssh.ExitError
+	if errors.As(err, &exitErr) {
+		return output.String(), exitErr.ExitStatus(), nil
+	}
+	if err != nil {
+		return output.String(), -1, err
+	}
+	return output.String(), 0, nil
+}
+
+func ExecInstances() error {
+
+	var region string
+	var insids string
+	var filterExpr string
+	var parallelism int
+	options := &sshOptions{}
+
+	cdrivers, err := parseAndGetDrivers(func() {
+		flag.StringVar(&region, "region", "", "实例所在地域，不填则为账户的默认地域，未设置默认地域或者填写all则为所有地域")
+		flag.StringVar(&insids, "insids", "", "实例ID，多个请用逗号隔开。如果不填则默认为所选择地域下的所有实例")
+		flag.StringVar(&filterExpr, "filter", "", "实例过滤条件，格式为key=pattern，多个条件用逗号隔开，key可选值为name、id、region，pattern支持*和?通配符，例如name=web-*")
+		flag.IntVar(&parallelism, "parallel", config.ActiveProfile().Parallelism, "同时执行命令的实例数量")
+		sshFlags(options)
+	}, func() error { return nil }, os.Args[3:])
+
+	if err != nil {
+		return err
+	}
+
+	command := strings.Join(flag.Args(), " ")
+	if command == "" {
+		return fmt.Errorf("需要执行的命令不能为空，命令写在--之后，例如 lhbin ins exec --filter name=web-* -- uptime")
+	}
+
+	filter, err := parseInstanceFilter(filterExpr)
+	if err != nil {
+		return err
+	}
+
+	targets := []*instanceTarget{}
+	for _, cdriver := range cdrivers {
+		accountTargets, err := collectInstances(cdriver, insids)
+		if err != nil {
+			return err
+		}
+		for _, target := range accountTargets {
+			if filter.match(target) {
+				targets = append(targets, target)
+			}
+		}
+	}
+
+	if len(targets) == 0 {
+		fmt.Println("没有符合条件的实例")
+		return nil
+	}
+
+	callback, err := hostKeyCallback(options.acceptNew)
+	if err != nil {
+		return err
+	}
+
+	// 先依次解析所有实例的连接信息，加密的私钥只需要输入一次密码
+	results := []*execResult{}
+	for _, target := range targets {
+		result := &execResult{target: target, exitCode: -1}
+		result.host, result.err = resolveSSHHost(target, options)
+		results = append(results, result)
+	}
+
+	if dryRun {
+		for _, result := range results {
+			if result.err == nil {
+				planned("将在%s地域的实例%s(%s)上执行命令: %s", result.target.region, result.target.name, result.target.insid, command)
+			}
+		}
+		return nil
+	}
+
+	// 先展示会在哪些实例上执行，确认后再执行
+	fmt.Println("将在以下实例上执行命令:", command)
+	fmt.Println("------------------------------------------")
+	fmt.Println(accountHeader() + "| 地域 | 实例名称 | 实例ID | 公网IP |")
+	fmt.Println("------------------------------------------")
+	for _, result := range results {
+		target := result.target
+		ip := ""
+		if result.host != nil {
+			ip = result.host.ip
+		} else {
+			ip = "无法连接:" + result.err.Error()
+		}
+		fmt.Print(accountColumn(target.account))
+		fmt.Println("|", target.region, "|", target.name, "|", target.insid, "|", ip, "|")
+		fmt.Println("------------------------------------------")
+	}
+
+	if !confirmRisk("命令会在以上实例上执行，请确认命令内容") {
+		return nil
+	}
+
+	if parallelism < 1 {
+		parallelism = 1
+	}
+
+	var wg sync.WaitGroup
+	limit := make(chan struct{}, parallelism)
+	for _, result := range results {
+		if result.err != nil {
+			continue
+		}
+
+		wg.Add(1)
+		limit <- struct{}{}
+		go func(result *execResult) {
+			defer wg.Done()
+			defer func() { <-limit }()
+			result.output, result.exitCode, result.err = runRemoteCommand(result.host, options, callback, command)
+		}(result)
+	}
+	wg.Wait()
+
+	for _, result := range results {
+		target := result.target
+		prefix := ""
+		if multiAccountMode {
+			prefix = "账户" + target.account
+		}
+		fmt.Printf("==================== %s%s地域的实例%s(%s) ====================\n", prefix, target.region, target.name, target.insid)
+		if result.output != "" {
+			fmt.Print(result.output)
+			if !strings.HasSuffix(result.output, "\n") {
+				fmt.Println()
+			}
+		}
+		if result.err != nil {
+			fmt.Println("执行失败:", result.err.Error())
+		}
+	}
+
+	fmt.Println("------------------------------------------")
+	fmt.Println(accountHeader() + "| 地域 | 实例名称 | 实例ID | 公网IP | 退出码 |")
+	fmt.Println("------------------------------------------")
+	failed := 0
+	for _, result := range results {
+		target := result.target
+		ip := ""
+		if result.host != nil {
+			ip = result.host.ip
+		}
+		if result.err != nil || result.exitCode != 0 {
+			failed++
+		}
+		fmt.Print(accountColumn(target.account))
+		fmt.Println("|", target.region, "|", target.name, "|", target.insid, "|", ip, "|", result.exitCode, "|")
+		fmt.Println("------------------------------------------")
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("有%d个实例上的命令没有执行成功", failed)
+	}
+	return nil
+}
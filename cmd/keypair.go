@@ -3,11 +3,12 @@ package cmd
 import (
 	"flag"
 	"fmt"
-	"io/ioutil"
 	"os"
 	"strings"
 
+	"github.com/lixiaofei123/lhbin/config"
 	"github.com/lixiaofei123/lhbin/driver"
+	"golang.org/x/crypto/ssh"
 )
 
 const KPCommandName string = "keypair"
@@ -18,6 +19,7 @@ func init() {
 	RegisterChildCommandOperator(KPCommandName, "list", "列出符合条件的密钥对", []string{}, SafeOperation(ListKeyPairs))
 	RegisterChildCommandOperator(KPCommandName, "import", "从已经存在的公钥创建密钥对", []string{}, SafeOperation(ImportKeyPair))
 	RegisterChildCommandOperator(KPCommandName, "create", "创建新的密钥对", []string{}, SafeOperation(CreateKeyPair))
+	RegisterChildCommandOperator(KPCommandName, "files", "列出本地登记的私钥文件", []string{}, SafeOperation(ListKeyFiles))
 	RegisterChildCommandOperator(KPCommandName, "del", "删除符合条件的密钥对", []string{"delete"}, RiskOperation("", DeleteKeyPairs))
 	RegisterChildCommandOperator(KPCommandName, "bind", "将密钥对绑定到指定的实例上", []string{}, RiskOperation("绑定过程会重启服务器，请注意保存好应用数据", BindKeyPairs))
 	RegisterChildCommandOperator(KPCommandName, "unbind", "将密钥对从指定的实例上解绑", []string{}, RiskOperation("解绑过程会重启服务器，请注意保存好应用数据", UnBindKeyPairs))
@@ -27,24 +29,67 @@ func CreateKeyPair() error {
 
 	var region string
 	var keyName string
+	var out string
+	var encrypt bool
+	var passphrase string
 
 	cdriver, err := parseAndGetDriver(func() {
 		flag.StringVar(&region, "region", "", "地域")
 		flag.StringVar(&keyName, "keyname", "", "密钥对名称")
+		flag.StringVar(&out, "out", "", "私钥保存路径，例如~/.ssh/name，公钥保存在同目录下的.pub文件中。不填则在终端输出私钥")
+		flag.BoolVar(&encrypt, "encrypt", false, "使用密码加密私钥，密码从终端读取")
 	}, func() error {
 		checkArg(&region, "地域不能为空")
 		checkArg(&keyName, "密钥对名称不能为空")
+		if out == "" && encrypt {
+			return fmt.Errorf("加密私钥需要同时设置私钥保存路径")
+		}
 		return nil
 	}, os.Args[3:])
 	if err != nil {
+		return err
+	}
+
+	if out != "" {
+		out, err = expandPath(out)
+		if err != nil {
+			return err
+		}
+		if err := checkKeyFilesAbsent(out); err != nil {
+			return err
+		}
+	}
+
+	if planned("将在%s地域创建密钥对%s", region, keyName) {
 		return nil
 	}
 
+	if encrypt {
+		passphrase, err = readPassphrase()
+		if err != nil {
+			return err
+		}
+	}
+
 	keypair, err := cdriver.CreateKeyPair(region, keyName)
 	if err != nil {
 		return err
 	}
 
+	if out != "" {
+		err := saveKeyPairFiles(keypair, out, passphrase)
+		if err == nil {
+			fmt.Printf("密钥对%s(%s)创建成功，私钥已保存到%s，公钥已保存到%s.pub \n", keypair.KeyName, keypair.KeyId, out, out)
+			// 私钥已经保存，登记失败只影响按密钥对查找私钥，不需要再输出私钥
+			if err := registerKeyFile(keypair, region, out, passphrase != ""); err != nil {
+				fmt.Printf("登记密钥对%s的私钥文件失败，原因是:%s \n", keypair.KeyId, err.Error())
+			}
+			return nil
+		}
+		// 私钥后续无法再次查询，写入失败时只能输出到终端
+		fmt.Printf("私钥保存失败，原因是:%s \n", err.Error())
+	}
+
 	fmt.Printf("密钥对%s(%s)创建成功，私钥后续无法查询，请注意保存私钥 \n", keypair.KeyName, keypair.KeyId)
 	fmt.Println("公钥为")
 	fmt.Println(keypair.PublicKey)
@@ -55,39 +100,140 @@ func CreateKeyPair() error {
 
 }
 
+func ListKeyFiles() error {
+	keys, err := config.ListKeyFiles()
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("------------------------------------------")
+	fmt.Println("| 密钥ID | 密钥名称 | 账户 | 地域 | 私钥文件 | 是否加密 |")
+	fmt.Println("------------------------------------------")
+	for _, key := range keys {
+		fmt.Println("|", key.KeyId, "|", key.KeyName, "|", key.Account, "|", key.Region, "|", key.Path, "|", key.Encrypted, "|")
+		fmt.Println("------------------------------------------")
+	}
+	return nil
+}
+
 func ImportKeyPair() error {
 
-	var region string
+	var regions string
 	var pubKeyPath string
 	var keyName string
+	var generate string
+	var bits int
+	var out string
+	var encrypt bool
+	var passphrase string
 
 	cdriver, err := parseAndGetDriver(func() {
-		flag.StringVar(&region, "region", "", "地域")
+		flag.StringVar(&regions, "region", "", "地域，多个用逗号隔开")
 		flag.StringVar(&keyName, "keyname", "", "密钥对名称")
 		flag.StringVar(&pubKeyPath, "pubKeyPath", "", "公钥文件路径")
+		flag.StringVar(&generate, "generate", "", "在本地生成新的密钥后导入，可选值为ed25519、rsa，设置后忽略pubKeyPath参数")
+		flag.StringVar(&out, "out", "", "生成的私钥保存路径，公钥保存在同目录下的.pub文件中，默认为~/.ssh/密钥对名称")
+		generateKeyFlags(&bits, &encrypt)
 	}, func() error {
-		checkArg(&region, "地域不能为空")
+		checkArg(&regions, "地域不能为空")
 		checkArg(&keyName, "密钥对名称不能为空")
-		checkArg(&pubKeyPath, "公钥文件路径不能为空")
+		if generate == "" {
+			checkArg(&pubKeyPath, "公钥文件路径不能为空")
+		} else if out == "" {
+			out = "~/.ssh/" + keyName
+		}
 		return nil
 	}, os.Args[3:])
 
-	if err != nil {
-		return nil
-	}
-
-	pubKeyData, err := ioutil.ReadFile(pubKeyPath)
 	if err != nil {
 		return err
 	}
 
-	keypair, err := cdriver.ImportKeyPair(region, keyName, string(pubKeyData))
-	if err != nil {
-		return err
+	var publicKey ssh.PublicKey
+	var publicKeyData string
+
+	if generate != "" {
+		out, err = expandPath(out)
+		if err != nil {
+			return err
+		}
+		if err := checkKeyFilesAbsent(out); err != nil {
+			return err
+		}
+
+		if planned("将在本地生成%s密钥%s，并导入到%s地域，密钥对名称为%s", generate, out, regions, keyName) {
+			return nil
+		}
+
+		if encrypt {
+			passphrase, err = readPassphrase()
+			if err != nil {
+				return err
+			}
+		}
+
+		publicKeyData, err = createLocalKey(generate, bits, keyName, out, passphrase)
+		if err != nil {
+			return err
+		}
+
+		publicKey, _, _, _, err = ssh.ParseAuthorizedKey([]byte(publicKeyData))
+		if err != nil {
+			return err
+		}
+	} else {
+		publicKey, publicKeyData, err = readPublicKeyFile(pubKeyPath)
+		if err != nil {
+			return err
+		}
+
+		if planned("将在%s地域导入密钥对%s，公钥文件为%s", regions, keyName, pubKeyPath) {
+			return nil
+		}
 	}
 
-	fmt.Printf("密钥对%s(%s)导入成功 \n", keypair.KeyName, keypair.KeyId)
+	printFingerprints(publicKey)
+	fingerprint := ssh.FingerprintSHA256(publicKey)
+
+	failed := []string{}
+	for _, region := range strings.Split(regions, ",") {
+		kps, err := cdriver.ListKeyPair(region)
+		if err != nil {
+			fmt.Printf("查询%s地域的密钥对失败，原因是:%s \n", region, err.Error())
+			failed = append(failed, region)
+			continue
+		}
+
+		var existed *driver.KeyPair
+		for _, kp := range kps {
+			if publicKeyFingerprint(kp.PublicKey) == fingerprint {
+				existed = kp
+				break
+			}
+		}
+		if existed != nil {
+			fmt.Printf("%s地域已经存在相同公钥的密钥对%s(%s)，跳过导入 \n", region, existed.KeyName, existed.KeyId)
+			continue
+		}
+
+		keypair, err := cdriver.ImportKeyPair(region, keyName, publicKeyData)
+		if err != nil {
+			fmt.Printf("%s地域的密钥对%s导入失败，原因是:%s \n", region, keyName, err.Error())
+			failed = append(failed, region)
+			continue
+		}
+		fmt.Printf("%s地域的密钥对%s(%s)导入成功 \n", region, keypair.KeyName, keypair.KeyId)
+
+		if generate != "" {
+			if err := registerKeyFile(keypair, region, out, passphrase != ""); err != nil {
+				fmt.Printf("登记密钥对%s的私钥文件失败，原因是:%s \n", keypair.KeyId, err.Error())
+			}
+		}
+	}
 
+	if len(failed) > 0 {
+		return fmt.Errorf("以下地域的密钥对导入失败:%s", strings.Join(failed, ","))
+	}
 	return nil
 
 }
@@ -96,45 +242,35 @@ func ListKeyPairs() error {
 
 	var region string
 
-	cdriver, err := parseAndGetDriverWithS(func() {
-		flag.StringVar(&region, "region", "", "地域，不填则默认为所有可用区")
-	}, os.Args[3:])
+	cdrivers, err := parseAndGetDrivers(func() {
+		flag.StringVar(&region, "region", "", "地域，不填则为账户的默认地域，未设置默认地域或者填写all则为所有地域")
+	}, func() error { return nil }, os.Args[3:])
 
 	if err != nil {
 		return err
 	}
 
 	fmt.Println("------------------------------------------")
-	fmt.Println("| 地域 | 密钥名称 | 密钥ID | 绑定实例 | 创建时间 |")
+	fmt.Println(accountHeader() + "| 地域 | 密钥名称 | 密钥ID | 绑定实例 | 创建时间 |")
 	fmt.Println("------------------------------------------")
 
-	if region == "" {
-		// 查询所有的
-		regions, err := cdriver.ListRegions()
+	for _, cdriver := range cdrivers {
+		regions, err := resolveRegions(cdriver.driver, cdriver.region)
 		if err != nil {
 			return err
 		}
 
 		for _, region := range regions {
-			kps, err := cdriver.ListKeyPair(region.Region)
+			kps, err := cdriver.driver.ListKeyPair(region)
 			if err != nil {
 				return err
 			}
 			for _, kp := range kps {
-				fmt.Println("|", region.Region, "|", kp.KeyName, "|", kp.KeyId, "|", strings.Join(kp.AssociatedInstanceIds, ","), "|", kp.CreatedTime.Format("2006-01-02 15:04:05"), "|")
+				fmt.Print(accountColumn(cdriver.account))
+				fmt.Println("|", region, "|", kp.KeyName, "|", kp.KeyId, "|", strings.Join(kp.AssociatedInstanceIds, ","), "|", kp.CreatedTime.Format("2006-01-02 15:04:05"), "|")
 				fmt.Println("------------------------------------------")
 			}
 		}
-
-	} else {
-		kps, err := cdriver.ListKeyPair(region)
-		if err != nil {
-			return err
-		}
-		for _, kp := range kps {
-			fmt.Println("|", region, "|", kp.KeyName, "|", kp.KeyId, "|", strings.Join(kp.AssociatedInstanceIds, ","), "|", kp.CreatedTime.Format("2006-01-02 15:04:05"), "|")
-			fmt.Println("------------------------------------------")
-		}
 	}
 
 	return nil
@@ -168,11 +304,15 @@ func DeleteKeyPairs() error {
 		kpids := strings.Split(keyIds, ",")
 
 		for _, kpid := range kpids {
+			if planned("将删除%s地域的密钥对%s", region, kpid) {
+				continue
+			}
 			err := cdriver.DeleteKeyPair(region, []string{kpid})
 			if err != nil {
 				fmt.Printf("%s地域的密钥对%s删除失败，原因是:%s \n", region, kpid, err.Error())
 			} else {
 				fmt.Printf("%s地域的密钥对%s删除成功 \n", region, kpid)
+				unregisterKeyFile(kpid)
 			}
 
 		}
@@ -182,11 +322,15 @@ func DeleteKeyPairs() error {
 			return err
 		}
 		for _, kp := range kps {
+			if planned("将删除%s地域的密钥对%s(%s)", region, kp.KeyName, kp.KeyId) {
+				continue
+			}
 			err := cdriver.DeleteKeyPair(region, []string{kp.KeyId})
 			if err != nil {
 				fmt.Printf("%s地域的密钥对%s删除失败，原因是:%s \n", region, kp.KeyId, err.Error())
 			} else {
 				fmt.Printf("%s地域的密钥对%s删除成功 \n", region, kp.KeyId)
+				unregisterKeyFile(kp.KeyId)
 			}
 		}
 	}
@@ -206,6 +350,9 @@ func BindKeyPairs() error {
 		checkArg(&keyId, "密钥对ID不能为空")
 		return nil
 	}, func(cdriver driver.Driver, region, name, insid string, args ...interface{}) {
+		if planned("将把%s地域的密钥对%s绑定到实例%s(%s)，绑定过程会重启实例", region, keyId, name, insid) {
+			return
+		}
 		err := cdriver.BindKeyPairs(region, []string{keyId}, []string{insid})
 		if err != nil {
 			fmt.Printf("%s地域的密钥对%s绑定到实例%s(%s)失败，原因是:%s \n", region, keyId, name, insid, err.Error())
@@ -227,6 +374,9 @@ func UnBindKeyPairs() error {
 		checkArg(&keyId, "密钥对ID不能为空")
 		return nil
 	}, func(cdriver driver.Driver, region, name, insid string, args ...interface{}) {
+		if planned("将把%s地域的密钥对%s从实例%s(%s)解绑，解绑过程会重启实例", region, keyId, name, insid) {
+			return
+		}
 		err := cdriver.UnBindKeyPairs(region, []string{keyId}, []string{insid})
 		if err != nil {
 			fmt.Printf("%s地域的密钥对%s从实例%s(%s)解绑失败，原因是:%s \n", region, keyId, name, insid, err.Error())
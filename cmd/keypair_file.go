@@ -0,0 +1,134 @@
+package cmd
+
+import (
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/lixiaofei123/lhbin/config"
+	"github.com/lixiaofei123/lhbin/driver"
+	"golang.org/x/crypto/ssh"
+)
+
+// expandPath 把路径开头的~替换为用户目录，并转换为绝对路径
+func expandPath(path string) (string, error) {
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		path = filepath.Join(home, path[1:])
+	}
+	return filepath.Abs(path)
+}
+
+// checkKeyFilesAbsent 私钥文件和公钥文件都不存在时才允许写入，避免覆盖已有的密钥
+func checkKeyFilesAbsent(privateKeyPath string) error {
+	for _, path := range []string{privateKeyPath, privateKeyPath + ".pub"} {
+		if _, err := os.Stat(path); err == nil {
+			return fmt.Errorf("文件%s已经存在，不会覆盖已有的密钥文件", path)
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// encryptPrivateKey 把私钥转换为OpenSSH格式并使用密码加密
+func encryptPrivateKey(privateKey, comment, passphrase string) (string, error) {
+	key, err := ssh.ParseRawPrivateKey([]byte(privateKey))
+	if err != nil {
+		return "", fmt.Errorf("解析私钥失败:%s", err.Error())
+	}
+
+	block, err := ssh.MarshalPrivateKeyWithPassphrase(key, comment, []byte(passphrase))
+	if err != nil {
+		return "", err
+	}
+	return string(pem.EncodeToMemory(block)), nil
+}
+
+// writeNewFile 以独占方式创建文件，文件已存在时返回错误
+func writeNewFile(path string, data []byte, perm os.FileMode) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(path)
+		return err
+	}
+	return f.Close()
+}
+
+// writeKeyFiles 把私钥写入privateKeyPath，公钥写入同目录下的.pub文件
+func writeKeyFiles(privateKeyPath, privateKey, publicKey string) error {
+	if err := os.MkdirAll(filepath.Dir(privateKeyPath), 0700); err != nil {
+		return err
+	}
+
+	if !strings.HasSuffix(privateKey, "\n") {
+		privateKey = privateKey + "\n"
+	}
+	if err := writeNewFile(privateKeyPath, []byte(privateKey), 0600); err != nil {
+		return err
+	}
+
+	publicKey = strings.TrimSpace(publicKey) + "\n"
+	return writeNewFile(privateKeyPath+".pub", []byte(publicKey), 0644)
+}
+
+// registerKeyFile 把密钥对和本地私钥文件的对应关系登记到本地的密钥登记文件中
+func registerKeyFile(keypair *driver.KeyPair, region, privateKeyPath string, encrypted bool) error {
+	return config.SaveKeyFile(&config.KeyFile{
+		KeyId:     keypair.KeyId,
+		KeyName:   keypair.KeyName,
+		Account:   currentAccount,
+		Region:    region,
+		Path:      privateKeyPath,
+		Encrypted: encrypted,
+	})
+}
+
+// saveKeyPairFiles 保存云端生成的密钥对的私钥和公钥，passphrase不为空时加密私钥
+func saveKeyPairFiles(keypair *driver.KeyPair, privateKeyPath, passphrase string) error {
+	privateKey := keypair.PrivateKey
+	if passphrase != "" {
+		encrypted, err := encryptPrivateKey(privateKey, keypair.KeyName, passphrase)
+		if err != nil {
+			return err
+		}
+		privateKey = encrypted
+	}
+
+	return writeKeyFiles(privateKeyPath, privateKey, keypair.PublicKey)
+}
+
+// unregisterKeyFile 删除密钥对后移除本地的登记记录，私钥文件本身保留，由用户自行处理
+func unregisterKeyFile(keyId string) {
+	keyFile, err := config.FindKeyFile(keyId)
+	if err != nil {
+		return
+	}
+	if err := config.DeleteKeyFile(keyId); err != nil {
+		fmt.Printf("移除密钥对%s的本地登记记录失败，原因是:%s \n", keyId, err.Error())
+		return
+	}
+	fmt.Printf("已移除密钥对%s的本地登记记录，私钥文件%s没有删除 \n", keyId, keyFile.Path)
+}
+
+// readPassphrase 从标准输入读取两次私钥密码，两次输入不一致时返回错误
+func readPassphrase() (string, error) {
+	passphrase := readPassword("请输入私钥密码:")
+	confirm := readPassword("请再次输入私钥密码:")
+	if passphrase == "" {
+		return "", fmt.Errorf("私钥密码不能为空")
+	}
+	if passphrase != confirm {
+		return "", fmt.Errorf("两次输入的私钥密码不一致")
+	}
+	return passphrase, nil
+}
@@ -0,0 +1,142 @@
+package cmd
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// minRSAKeyBits 低于此长度的RSA公钥拒绝导入
+const minRSAKeyBits = 2048
+
+// generateSSHKey 在本地生成密钥，返回OpenSSH格式的私钥和authorized_keys格式的公钥
+func generateSSHKey(keyType string, bits int, comment, passphrase string) (string, string, error) {
+	var privateKey crypto.PrivateKey
+	switch keyType {
+	case "ed25519":
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return "", "", err
+		}
+		privateKey = key
+	case "rsa":
+		if bits < minRSAKeyBits {
+			return "", "", fmt.Errorf("RSA密钥长度不能小于%d", minRSAKeyBits)
+		}
+		key, err := rsa.GenerateKey(rand.Reader, bits)
+		if err != nil {
+			return "", "", err
+		}
+		privateKey = key
+	default:
+		return "", "", fmt.Errorf("不支持的密钥类型%s，可选值为ed25519、rsa", keyType)
+	}
+
+	signer, err := ssh.NewSignerFromKey(privateKey)
+	if err != nil {
+		return "", "", err
+	}
+
+	var block *pem.Block
+	if passphrase != "" {
+		block, err = ssh.MarshalPrivateKeyWithPassphrase(privateKey, comment, []byte(passphrase))
+	} else {
+		block, err = ssh.MarshalPrivateKey(privateKey, comment)
+	}
+	if err != nil {
+		return "", "", err
+	}
+
+	publicKey := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(signer.PublicKey())))
+	if comment != "" {
+		publicKey = publicKey + " " + comment
+	}
+	return string(pem.EncodeToMemory(block)), publicKey, nil
+}
+
+// generateKeyFlags 在本地生成密钥时的密钥长度和加密参数，kp import和kp rotate共用
+func generateKeyFlags(bits *int, encrypt *bool) {
+	flag.IntVar(bits, "bits", 4096, "生成RSA密钥时的密钥长度")
+	flag.BoolVar(encrypt, "encrypt", false, "使用密码加密生成的私钥，密码从终端读取")
+}
+
+// createLocalKey 在本地生成密钥并写入文件，返回authorized_keys格式的公钥
+func createLocalKey(keyType string, bits int, keyName, privateKeyPath, passphrase string) (string, error) {
+	privateKey, publicKey, err := generateSSHKey(keyType, bits, keyName, passphrase)
+	if err != nil {
+		return "", err
+	}
+	if err := writeKeyFiles(privateKeyPath, privateKey, publicKey); err != nil {
+		return "", err
+	}
+	fmt.Printf("私钥已保存到%s，公钥已保存到%s.pub \n", privateKeyPath, privateKeyPath)
+	return publicKey, nil
+}
+
+// validatePublicKey 检查公钥的类型和长度是否可以导入
+func validatePublicKey(publicKey ssh.PublicKey) error {
+	switch publicKey.Type() {
+	case ssh.KeyAlgoED25519:
+		return nil
+	case ssh.KeyAlgoRSA:
+		cryptoKey, ok := publicKey.(ssh.CryptoPublicKey)
+		if !ok {
+			return fmt.Errorf("无法解析RSA公钥")
+		}
+		rsaKey, ok := cryptoKey.CryptoPublicKey().(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("无法解析RSA公钥")
+		}
+		if rsaKey.N.BitLen() < minRSAKeyBits {
+			return fmt.Errorf("RSA公钥长度为%d，不能小于%d", rsaKey.N.BitLen(), minRSAKeyBits)
+		}
+		return nil
+	default:
+		return fmt.Errorf("不支持导入%s类型的公钥，只支持ssh-ed25519和ssh-rsa", publicKey.Type())
+	}
+}
+
+// readPublicKeyFile 读取并校验authorized_keys格式的公钥文件
+func readPublicKeyFile(path string) (ssh.PublicKey, string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, "", err
+	}
+
+	publicKey, _, _, rest, err := ssh.ParseAuthorizedKey(data)
+	if err != nil {
+		return nil, "", fmt.Errorf("公钥文件%s格式错误:%s", path, err.Error())
+	}
+	if strings.TrimSpace(string(rest)) != "" {
+		return nil, "", fmt.Errorf("公钥文件%s包含多个公钥，每次只能导入一个", path)
+	}
+
+	if err := validatePublicKey(publicKey); err != nil {
+		return nil, "", err
+	}
+
+	return publicKey, strings.TrimSpace(string(data)), nil
+}
+
+// publicKeyFingerprint 返回公钥的SHA256指纹，公钥无法解析时返回空字符串
+func publicKeyFingerprint(publicKey string) string {
+	key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(publicKey))
+	if err != nil {
+		return ""
+	}
+	return ssh.FingerprintSHA256(key)
+}
+
+func printFingerprints(publicKey ssh.PublicKey) {
+	fmt.Println("公钥类型:", publicKey.Type())
+	fmt.Println("MD5指纹:", ssh.FingerprintLegacyMD5(publicKey))
+	fmt.Println("SHA256指纹:", ssh.FingerprintSHA256(publicKey))
+}
@@ -0,0 +1,252 @@
+package cmd
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/lixiaofei123/lhbin/config"
+)
+
+func init() {
+	RegisterChildCommandOperator(KPCommandName, "matrix", "查看实例和密钥对的对应关系", []string{}, SafeOperation(KeyPairMatrix))
+}
+
+// matrixKeyPair 密钥对以及它绑定的实例和本地对应的公钥、私钥文件
+type matrixKeyPair struct {
+	Account     string   `json:"account,omitempty"`
+	Region      string   `json:"region"`
+	KeyId       string   `json:"keyId"`
+	KeyName     string   `json:"keyName"`
+	Fingerprint string   `json:"fingerprint"`
+	Instances   []string `json:"instances"`
+	LocalFiles  []string `json:"localFiles"`
+}
+
+// matrixInstance 实例以及绑定在实例上的密钥对
+type matrixInstance struct {
+	Account  string   `json:"account,omitempty"`
+	Region   string   `json:"region"`
+	ID       string   `json:"id"`
+	Name     string   `json:"name"`
+	Platform string   `json:"platform"`
+	KeyIds   []string `json:"keyIds"`
+}
+
+type keyPairMatrix struct {
+	Instances        []*matrixInstance `json:"instances"`
+	KeyPairs         []*matrixKeyPair  `json:"keyPairs"`
+	UnboundKeyPairs  []string          `json:"unboundKeyPairs"`
+	KeylessInstances []string          `json:"keylessInstances"`
+}
+
+// localPublicKeys 收集目录下的*.pub文件和本地登记的私钥对应的公钥文件，返回指纹到文件路径的映射
+func localPublicKeys(dir string) (map[string][]string, error) {
+	paths := []string{}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, file := range files {
+		if !file.IsDir() && strings.HasSuffix(file.Name(), ".pub") {
+			paths = append(paths, filepath.Join(dir, file.Name()))
+		}
+	}
+
+	keyFiles, err := config.ListKeyFiles()
+	if err != nil {
+		return nil, err
+	}
+	for _, keyFile := range keyFiles {
+		paths = append(paths, keyFile.Path+".pub")
+	}
+
+	fingerprints := map[string][]string{}
+	seen := map[string]bool{}
+	for _, path := range paths {
+		if seen[path] {
+			continue
+		}
+		seen[path] = true
+
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		// 公钥文件无法解析时忽略，~/.ssh下可能有其它用途的.pub文件
+		fingerprint := publicKeyFingerprint(string(data))
+		if fingerprint == "" {
+			continue
+		}
+		fingerprints[fingerprint] = append(fingerprints[fingerprint], strings.TrimSuffix(path, ".pub"))
+	}
+	return fingerprints, nil
+}
+
+func collectKeyPairMatrix(cdrivers []*accountDriver, sshDir string) (*keyPairMatrix, error) {
+	locals, err := localPublicKeys(sshDir)
+	if err != nil {
+		return nil, err
+	}
+
+	matrix := &keyPairMatrix{
+		Instances:        []*matrixInstance{},
+		KeyPairs:         []*matrixKeyPair{},
+		UnboundKeyPairs:  []string{},
+		KeylessInstances: []string{},
+	}
+
+	for _, cdriver := range cdrivers {
+		regions, err := resolveRegions(cdriver.driver, cdriver.region)
+		if err != nil {
+			return nil, err
+		}
+
+		// 同一个密钥对在多个地域查询时可能重复返回，按密钥ID合并绑定的实例
+		keyPairs := map[string]*matrixKeyPair{}
+		instances := map[string]*matrixInstance{}
+
+		for _, region := range regions {
+			inss, err := cdriver.driver.ListInstances(region)
+			if err != nil {
+				return nil, err
+			}
+			for _, ins := range inss {
+				instance := &matrixInstance{Account: cdriver.account, Region: region, ID: ins.ID, Name: ins.Name, Platform: ins.PlatformType, KeyIds: []string{}}
+				instances[ins.ID] = instance
+				matrix.Instances = append(matrix.Instances, instance)
+			}
+
+			kps, err := cdriver.driver.ListKeyPair(region)
+			if err != nil {
+				return nil, err
+			}
+			for _, kp := range kps {
+				keyPair, ok := keyPairs[kp.KeyId]
+				if !ok {
+					fingerprint := publicKeyFingerprint(kp.PublicKey)
+					keyPair = &matrixKeyPair{Account: cdriver.account, Region: region, KeyId: kp.KeyId, KeyName: kp.KeyName, Fingerprint: fingerprint, Instances: []string{}, LocalFiles: []string{}}
+					if fingerprint != "" {
+						keyPair.LocalFiles = append(keyPair.LocalFiles, locals[fingerprint]...)
+					}
+					keyPairs[kp.KeyId] = keyPair
+					matrix.KeyPairs = append(matrix.KeyPairs, keyPair)
+				}
+				for _, insid := range kp.AssociatedInstanceIds {
+					if !containsString(keyPair.Instances, insid) {
+						keyPair.Instances = append(keyPair.Instances, insid)
+					}
+				}
+			}
+		}
+
+		for _, keyPair := range keyPairs {
+			for _, insid := range keyPair.Instances {
+				if instance, ok := instances[insid]; ok && !containsString(instance.KeyIds, keyPair.KeyId) {
+					instance.KeyIds = append(instance.KeyIds, keyPair.KeyId)
+				}
+			}
+		}
+	}
+
+	for _, keyPair := range matrix.KeyPairs {
+		sort.Strings(keyPair.Instances)
+		if len(keyPair.Instances) == 0 {
+			matrix.UnboundKeyPairs = append(matrix.UnboundKeyPairs, keyPair.KeyId)
+		}
+	}
+	for _, instance := range matrix.Instances {
+		sort.Strings(instance.KeyIds)
+		if len(instance.KeyIds) == 0 {
+			matrix.KeylessInstances = append(matrix.KeylessInstances, instance.ID)
+		}
+	}
+
+	return matrix, nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func KeyPairMatrix() error {
+
+	var region string
+	var sshDir string
+	var output string
+
+	cdrivers, err := parseAndGetDrivers(func() {
+		flag.StringVar(&region, "region", "", "地域，不填则为账户的默认地域，未设置默认地域或者填写all则为所有地域")
+		flag.StringVar(&sshDir, "ssh-dir", "~/.ssh", "本地公钥所在的目录，目录下的*.pub文件会和密钥对的指纹进行比对")
+		outputFlag(&output)
+	}, func() error { return nil }, os.Args[3:])
+
+	if err != nil {
+		return err
+	}
+
+	sshDir, err = expandPath(sshDir)
+	if err != nil {
+		return err
+	}
+
+	matrix, err := collectKeyPairMatrix(cdrivers, sshDir)
+	if err != nil {
+		return err
+	}
+
+	if output == string(config.JsonOutput) {
+		return printJson(matrix)
+	}
+
+	keyNames := []string{}
+	for _, keyPair := range matrix.KeyPairs {
+		keyNames = append(keyNames, keyPair.KeyName)
+	}
+
+	fmt.Println("------------------------------------------")
+	fmt.Println(accountHeader() + "| 地域 | 实例名称 | 实例ID | " + strings.Join(keyNames, " | ") + " |")
+	fmt.Println("------------------------------------------")
+	for _, instance := range matrix.Instances {
+		cells := []string{}
+		for _, keyPair := range matrix.KeyPairs {
+			if keyPair.Account == instance.Account && containsString(instance.KeyIds, keyPair.KeyId) {
+				cells = append(cells, "Y")
+			} else {
+				cells = append(cells, "-")
+			}
+		}
+		fmt.Print(accountColumn(instance.Account))
+		fmt.Println("|", instance.Region, "|", instance.Name, "|", instance.ID, "|", strings.Join(cells, " | "), "|")
+		fmt.Println("------------------------------------------")
+	}
+
+	fmt.Println()
+	fmt.Println("------------------------------------------")
+	fmt.Println(accountHeader() + "| 密钥名称 | 密钥ID | SHA256指纹 | 绑定实例数 | 本地私钥 |")
+	fmt.Println("------------------------------------------")
+	for _, keyPair := range matrix.KeyPairs {
+		fmt.Print(accountColumn(keyPair.Account))
+		fmt.Println("|", keyPair.KeyName, "|", keyPair.KeyId, "|", keyPair.Fingerprint, "|", len(keyPair.Instances), "|", strings.Join(keyPair.LocalFiles, ","), "|")
+		fmt.Println("------------------------------------------")
+	}
+
+	if len(matrix.UnboundKeyPairs) > 0 {
+		fmt.Println("以下密钥对没有绑定任何实例:", strings.Join(matrix.UnboundKeyPairs, ","))
+	}
+	if len(matrix.KeylessInstances) > 0 {
+		fmt.Println("以下实例没有绑定密钥对，只能通过密码登录:", strings.Join(matrix.KeylessInstances, ","))
+	}
+
+	return nil
+}
@@ -0,0 +1,387 @@
+package cmd
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/lixiaofei123/lhbin/driver"
+)
+
+func init() {
+	RegisterChildCommandOperator(KPCommandName, "rotate", "把实例上的旧密钥对替换为新密钥对", []string{}, SafeOperation(RotateKeyPairs))
+}
+
+const (
+	bindKeyPairsOperation   = "AssociateInstancesKeyPairs"
+	unbindKeyPairsOperation = "DisassociateInstancesKeyPairs"
+)
+
+// keyPairPollInterval 查询密钥对操作进度的间隔
+var keyPairPollInterval = 10 * time.Second
+
+// keyRotateInstance 参与密钥轮换的实例
+type keyRotateInstance struct {
+	insid     string
+	name      string
+	state     driver.InstanceState // 轮换前的状态，每一步结束后需要恢复到此状态
+	bindNew   bool                 // 新密钥对还没有绑定，需要绑定
+	unbindOld bool
+}
+
+// waitKeyPairOperation 等待实例上的密钥对操作结束，并且实例恢复到操作前的状态。
+// 有实例操作失败时也会等待其它实例结束，避免回滚时操作还在进行中的实例
+func waitKeyPairOperation(cdriver driver.Driver, region string, instances []*keyRotateInstance, operation string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	pending := instances
+	failed := []string{}
+	for len(pending) > 0 {
+		// 接口返回后实例的最新操作不一定马上更新，先等待一段时间再查询
+		time.Sleep(keyPairPollInterval)
+
+		next := []*keyRotateInstance{}
+		for _, instance := range pending {
+			info, err := cdriver.InstanceInfo(region, instance.insid)
+			if err != nil {
+				next = append(next, instance)
+				continue
+			}
+			if info.LatestOperation == operation && info.LatestOperationState == "FAILED" {
+				failed = append(failed, fmt.Sprintf("%s(%s)", instance.name, instance.insid))
+				continue
+			}
+			if info.LatestOperation != operation || info.LatestOperationState != "SUCCESS" || info.State != instance.state {
+				next = append(next, instance)
+			}
+		}
+
+		pending = next
+		if len(pending) == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			insids := []string{}
+			for _, instance := range pending {
+				insids = append(insids, instance.insid)
+			}
+			return fmt.Errorf("等待实例%s的%s操作结束超时", strings.Join(insids, ","), operation)
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("实例%s的%s操作失败", strings.Join(failed, ","), operation)
+	}
+	return nil
+}
+
+func rotateInstanceIds(instances []*keyRotateInstance, selected func(instance *keyRotateInstance) bool) ([]string, []*keyRotateInstance) {
+	insids := []string{}
+	selectedInstances := []*keyRotateInstance{}
+	for _, instance := range instances {
+		if selected(instance) {
+			insids = append(insids, instance.insid)
+			selectedInstances = append(selectedInstances, instance)
+		}
+	}
+	return insids, selectedInstances
+}
+
+// rotateKeyPairBatch 对一批实例先统一绑定新密钥对，等待实例恢复后再统一解绑旧密钥对，同一批实例每一步只调用一次接口
+func rotateKeyPairBatch(cdriver driver.Driver, region, oldKeyId, newKeyId string, batch []*keyRotateInstance, timeout time.Duration) error {
+	insids, instances := rotateInstanceIds(batch, func(instance *keyRotateInstance) bool { return instance.bindNew })
+	if len(insids) > 0 {
+		fmt.Printf("正在把密钥对%s绑定到实例%s \n", newKeyId, strings.Join(insids, ","))
+		if err := cdriver.BindKeyPairs(region, []string{newKeyId}, insids); err != nil {
+			return err
+		}
+		if err := waitKeyPairOperation(cdriver, region, instances, bindKeyPairsOperation, timeout); err != nil {
+			return err
+		}
+	}
+
+	insids, instances = rotateInstanceIds(batch, func(instance *keyRotateInstance) bool { return instance.unbindOld })
+	if len(insids) > 0 {
+		fmt.Printf("正在把密钥对%s从实例%s解绑 \n", oldKeyId, strings.Join(insids, ","))
+		if err := cdriver.UnBindKeyPairs(region, []string{oldKeyId}, insids); err != nil {
+			return err
+		}
+		if err := waitKeyPairOperation(cdriver, region, instances, unbindKeyPairsOperation, timeout); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rollbackKeyPairBatch 把一批实例恢复为轮换前的密钥对：重新绑定旧密钥对，解绑本次绑定的新密钥对
+func rollbackKeyPairBatch(cdriver driver.Driver, region, oldKeyId, newKeyId string, batch []*keyRotateInstance, timeout time.Duration) error {
+	kps, err := cdriver.ListKeyPair(region)
+	if err != nil {
+		return err
+	}
+	bound := map[string]bool{}
+	for _, kp := range kps {
+		for _, insid := range kp.AssociatedInstanceIds {
+			bound[kp.KeyId+"/"+insid] = true
+		}
+	}
+
+	insids, instances := rotateInstanceIds(batch, func(instance *keyRotateInstance) bool {
+		return instance.unbindOld && !bound[oldKeyId+"/"+instance.insid]
+	})
+	if len(insids) > 0 {
+		fmt.Printf("回滚:正在把密钥对%s重新绑定到实例%s \n", oldKeyId, strings.Join(insids, ","))
+		if err := cdriver.BindKeyPairs(region, []string{oldKeyId}, insids); err != nil {
+			return err
+		}
+		if err := waitKeyPairOperation(cdriver, region, instances, bindKeyPairsOperation, timeout); err != nil {
+			return err
+		}
+	}
+
+	insids, instances = rotateInstanceIds(batch, func(instance *keyRotateInstance) bool {
+		return instance.bindNew && bound[newKeyId+"/"+instance.insid]
+	})
+	if len(insids) > 0 {
+		fmt.Printf("回滚:正在把密钥对%s从实例%s解绑 \n", newKeyId, strings.Join(insids, ","))
+		if err := cdriver.UnBindKeyPairs(region, []string{newKeyId}, insids); err != nil {
+			return err
+		}
+		if err := waitKeyPairOperation(cdriver, region, instances, unbindKeyPairsOperation, timeout); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func RotateKeyPairs() error {
+
+	var region string
+	var oldKeyId string
+	var newKeyId string
+	var generate string
+	var keyName string
+	var out string
+	var bits int
+	var encrypt bool
+	var insids string
+	var batchSize int
+	var keepOld bool
+	var timeout time.Duration
+
+	cdriver, err := parseAndGetDriver(func() {
+		flag.StringVar(&region, "region", "", "地域")
+		flag.StringVar(&oldKeyId, "old", "", "需要替换的旧密钥对ID")
+		flag.StringVar(&newKeyId, "new", "", "新密钥对ID")
+		flag.StringVar(&generate, "generate", "", "在本地生成新的密钥并导入作为新密钥对，可选值为ed25519、rsa，设置后忽略new参数")
+		flag.StringVar(&keyName, "keyname", "", "生成的新密钥对名称，默认为旧密钥对名称加上当前日期")
+		flag.StringVar(&out, "out", "", "生成的私钥保存路径，默认为~/.ssh/新密钥对名称")
+		generateKeyFlags(&bits, &encrypt)
+		flag.StringVar(&insids, "insids", "", "只轮换这些实例，多个用逗号隔开，不填则为绑定了旧密钥对的所有实例")
+		flag.IntVar(&batchSize, "batch", 5, "每批同时操作的实例数量，同一批实例一起绑定和解绑")
+		flag.BoolVar(&keepOld, "keep-old", false, "绑定新密钥对后不解绑旧密钥对")
+		flag.DurationVar(&timeout, "timeout", 10*time.Minute, "每一步等待实例恢复的最长时间")
+	}, func() error {
+		checkArg(&region, "地域不能为空")
+		checkArg(&oldKeyId, "旧密钥对ID不能为空")
+		if generate == "" {
+			checkArg(&newKeyId, "新密钥对ID不能为空，也可以通过generate参数生成新的密钥")
+		}
+		if batchSize < 1 {
+			return fmt.Errorf("每批的实例数量不能小于1")
+		}
+		return nil
+	}, os.Args[3:])
+
+	if err != nil {
+		return err
+	}
+
+	kps, err := cdriver.ListKeyPair(region)
+	if err != nil {
+		return err
+	}
+
+	var oldKeyPair, newKeyPair *driver.KeyPair
+	for _, kp := range kps {
+		if kp.KeyId == oldKeyId {
+			oldKeyPair = kp
+		}
+		if generate == "" && kp.KeyId == newKeyId {
+			newKeyPair = kp
+		}
+	}
+	if oldKeyPair == nil {
+		return fmt.Errorf("%s地域下不存在密钥对%s", region, oldKeyId)
+	}
+	if generate == "" && newKeyPair == nil {
+		return fmt.Errorf("%s地域下不存在密钥对%s", region, newKeyId)
+	}
+	if oldKeyId == newKeyId {
+		return fmt.Errorf("新旧密钥对不能相同")
+	}
+
+	newBound := map[string]bool{}
+	if newKeyPair != nil {
+		for _, insid := range newKeyPair.AssociatedInstanceIds {
+			newBound[insid] = true
+		}
+	}
+
+	selected := map[string]bool{}
+	if insids != "" {
+		for _, insid := range strings.Split(insids, ",") {
+			selected[insid] = true
+		}
+	}
+
+	instances := []*keyRotateInstance{}
+	for _, insid := range oldKeyPair.AssociatedInstanceIds {
+		if insids != "" && !selected[insid] {
+			continue
+		}
+		info, err := cdriver.InstanceInfo(region, insid)
+		if err != nil {
+			return err
+		}
+		if info.State != driver.Running && info.State != driver.Stoped {
+			return fmt.Errorf("实例%s(%s)当前状态为%s，只能轮换运行中或者已关机的实例", info.Name, insid, info.State)
+		}
+		if newBound[insid] && keepOld {
+			continue
+		}
+		instances = append(instances, &keyRotateInstance{
+			insid:     insid,
+			name:      info.Name,
+			state:     info.State,
+			bindNew:   !newBound[insid],
+			unbindOld: !keepOld,
+		})
+	}
+
+	if len(instances) == 0 {
+		fmt.Printf("没有需要轮换的实例，密钥对%s没有绑定到任何符合条件的实例 \n", oldKeyId)
+		return nil
+	}
+
+	// 已关机的实例绑定和解绑密钥对都不需要重启，和运行中的实例分开批次，避免拖慢需要重启的批次
+	running := []*keyRotateInstance{}
+	stopped := []*keyRotateInstance{}
+	for _, instance := range instances {
+		if instance.state == driver.Running {
+			running = append(running, instance)
+		} else {
+			stopped = append(stopped, instance)
+		}
+	}
+
+	batches := [][]*keyRotateInstance{}
+	for _, group := range [][]*keyRotateInstance{running, stopped} {
+		for start := 0; start < len(group); start += batchSize {
+			end := start + batchSize
+			if end > len(group) {
+				end = len(group)
+			}
+			batches = append(batches, group[start:end])
+		}
+	}
+
+	newKeyDesc := newKeyId
+	if generate != "" {
+		if keyName == "" {
+			keyName = oldKeyPair.KeyName + "_" + time.Now().Format("20060102")
+		}
+		newKeyDesc = fmt.Sprintf("新生成的%s密钥对%s", generate, keyName)
+	}
+
+	fmt.Printf("将在%s地域把%d个实例上的密钥对%s(%s)替换为%s，分为%d批执行 \n", region, len(instances), oldKeyPair.KeyName, oldKeyId, newKeyDesc, len(batches))
+	reboots := 0
+	for index, batch := range batches {
+		for _, instance := range batch {
+			steps := []string{}
+			if instance.bindNew {
+				steps = append(steps, "绑定新密钥对")
+			}
+			if instance.unbindOld {
+				steps = append(steps, "解绑旧密钥对")
+			}
+			// 运行中的实例每次绑定或者解绑都会重启一次
+			reboot := "已关机，不需要重启"
+			if instance.state == driver.Running {
+				reboot = fmt.Sprintf("需要重启%d次", len(steps))
+				reboots += len(steps)
+			}
+			fmt.Printf("第%d批: %s(%s) 当前状态%s，%s，%s \n", index+1, instance.name, instance.insid, instance.state, strings.Join(steps, "，"), reboot)
+		}
+	}
+
+	if dryRun {
+		return nil
+	}
+
+	tips := "已关机的实例绑定和解绑密钥对不需要重启"
+	if reboots > 0 {
+		tips = fmt.Sprintf("运行中的实例共需要重启%d次，请注意保存好应用数据", reboots)
+	}
+	if !confirmRisk(tips) {
+		return nil
+	}
+
+	if generate != "" {
+		if out == "" {
+			out = "~/.ssh/" + keyName
+		}
+		out, err = expandPath(out)
+		if err != nil {
+			return err
+		}
+		if err := checkKeyFilesAbsent(out); err != nil {
+			return err
+		}
+		passphrase := ""
+		if encrypt {
+			passphrase, err = readPassphrase()
+			if err != nil {
+				return err
+			}
+		}
+		publicKey, err := createLocalKey(generate, bits, keyName, out, passphrase)
+		if err != nil {
+			return err
+		}
+		newKeyPair, err = cdriver.ImportKeyPair(region, keyName, publicKey)
+		if err != nil {
+			return err
+		}
+		newKeyId = newKeyPair.KeyId
+		fmt.Printf("%s地域的密钥对%s(%s)导入成功 \n", region, newKeyPair.KeyName, newKeyId)
+		if err := registerKeyFile(newKeyPair, region, out, passphrase != ""); err != nil {
+			fmt.Printf("登记密钥对%s的私钥文件失败，原因是:%s \n", newKeyId, err.Error())
+		}
+	}
+
+	rotated := []string{}
+	for index, batch := range batches {
+		fmt.Printf("开始执行第%d批 \n", index+1)
+		err := rotateKeyPairBatch(cdriver, region, oldKeyId, newKeyId, batch, timeout)
+		if err != nil {
+			fmt.Printf("第%d批执行失败，原因是:%s，开始回滚此批实例 \n", index+1, err.Error())
+			if rollbackErr := rollbackKeyPairBatch(cdriver, region, oldKeyId, newKeyId, batch, timeout); rollbackErr != nil {
+				fmt.Printf("第%d批回滚失败，原因是:%s，请手动检查实例绑定的密钥对 \n", index+1, rollbackErr.Error())
+			} else {
+				fmt.Printf("第%d批回滚成功 \n", index+1)
+			}
+			if len(rotated) > 0 {
+				fmt.Printf("以下实例已经完成轮换:%s \n", strings.Join(rotated, ","))
+			}
+			return fmt.Errorf("密钥对轮换在第%d批失败，后续批次没有执行", index+1)
+		}
+		for _, instance := range batch {
+			rotated = append(rotated, instance.insid)
+		}
+	}
+
+	fmt.Printf("%d个实例的密钥对已经替换为%s \n", len(rotated), newKeyId)
+	return nil
+}
@@ -6,6 +6,7 @@ import (
 	"os"
 	"strings"
 
+	"github.com/lixiaofei123/lhbin/config"
 	"github.com/lixiaofei123/lhbin/driver"
 )
 
@@ -15,25 +16,30 @@ func init() {
 
 	RegisterChildCommand(SnapshotCommandName, "管理快照信息", []string{"ss"})
 	RegisterChildCommandOperator(SnapshotCommandName, "list", "列出符合要求的快照列表", []string{}, SafeOperation(ListSnapshots))
+	RegisterChildCommandOperator(SnapshotCommandName, "list-region", "列出地域下的所有快照，包括已经销毁的实例留下的快照", []string{"lsr"}, SafeOperation(ListRegionSnapshots))
 	RegisterChildCommandOperator(SnapshotCommandName, "desc", "查看符合要求的快照详情", []string{"describe"}, SafeOperation(DescribeSnapshots))
-	RegisterChildCommandOperator(SnapshotCommandName, "del", "删除符合要求的快照", []string{"delete"}, RiskOperation("", DeleteSnapshots))
+	RegisterChildCommandOperator(SnapshotCommandName, "del", "删除符合要求的快照", []string{"delete"}, SafeOperation(DeleteSnapshots))
 	RegisterChildCommandOperator(SnapshotCommandName, "create", "创建快照信息", []string{}, SafeOperation(CreateSnapshot))
 	RegisterChildCommandOperator(SnapshotCommandName, "apply", "恢复快照", []string{}, DangerOperation("恢复快照会丢失创建快照以后的数据", ApplySnapshot))
 
 }
 
 func ListSnapshots() error {
-	fmt.Println("------------------------------------------")
-	fmt.Println("| 地域 | 实例名称 | 实例ID | 快照名称 | 快照ID | 创建时间 |状态 |")
-	fmt.Println("------------------------------------------")
 
-	err := baseBatchOperatorInstances(false, func(region string, insids string) error { return nil }, func(cdriver driver.Driver, region, name, insid string, args ...interface{}) {
-		snapshots, err := cdriver.ListSnapshots(region, insid)
+	err := baseBatchOperatorInstances(false, func(region string, insids string) error {
+		fmt.Println("------------------------------------------")
+		fmt.Println(accountHeader() + "| 地域 | 实例名称 | 实例ID | 快照名称 | 快照ID | 创建时间 |状态 |")
+		fmt.Println("------------------------------------------")
+		return nil
+	}, func(cdriver driver.Driver, region, name, insid string, args ...interface{}) {
+		snapshots, err := cdriver.ListSnapshots(region, &driver.SnapshotFilter{InstanceId: insid})
 		if err != nil {
+			fmt.Print(accountColumn(accountOf(args)))
 			fmt.Printf("|%s|%s(%s)|查询失败，原因:%s|\n", region, name, insid, err.Error())
 			fmt.Println("------------------------------------------")
 		} else {
 			for _, snapshot := range snapshots {
+				fmt.Print(accountColumn(accountOf(args)))
 				fmt.Println("|", region, "|", name, "|", insid, "|", snapshot.Name, "|", snapshot.SnapShot, "|", snapshot.CreatedTime.Format("2006-01-02 15:04:05"), "|", snapshot.State, "|")
 				fmt.Println("------------------------------------------")
 			}
@@ -47,6 +53,71 @@ func ListSnapshots() error {
 
 }
 
+// regionSnapshot 多账户模式下JSON输出的快照信息
+type regionSnapshot struct {
+	Account string
+	Region  string
+	*driver.SnapShot
+}
+
+func ListRegionSnapshots() error {
+
+	var region string
+	var output string
+	var orphaned bool
+
+	cdrivers, err := parseAndGetDrivers(func() {
+		flag.StringVar(&region, "region", "", "地域，不填则为账户的默认地域，未设置默认地域或者填写all则为所有地域")
+		flag.BoolVar(&orphaned, "orphaned", false, "只列出已经销毁的实例留下的快照")
+		outputFlag(&output)
+	}, func() error { return nil }, os.Args[3:])
+
+	if err != nil {
+		return err
+	}
+
+	snapshots := []*regionSnapshot{}
+	for _, cdriver := range cdrivers {
+		regions, err := resolveRegions(cdriver.driver, cdriver.region)
+		if err != nil {
+			return err
+		}
+
+		for _, region := range regions {
+			sss, err := cdriver.driver.ListSnapshots(region, nil)
+			if err != nil {
+				return err
+			}
+			for _, ss := range sss {
+				if orphaned && ss.InstanceId != "" {
+					continue
+				}
+				snapshots = append(snapshots, &regionSnapshot{Account: cdriver.account, Region: region, SnapShot: ss})
+			}
+		}
+	}
+
+	if output == string(config.JsonOutput) {
+		return printJson(snapshots)
+	}
+
+	fmt.Println("------------------------------------------")
+	fmt.Println(accountHeader() + "| 地域 | 快照ID | 快照名称 | 所属实例 | 磁盘ID | 磁盘大小 | 创建时间 | 状态 | 最新操作 |")
+	fmt.Println("------------------------------------------")
+	for _, ss := range snapshots {
+		instance := ss.InstanceId
+		if instance == "" {
+			instance = "实例已销毁"
+		}
+		fmt.Print(accountColumn(ss.Account))
+		fmt.Println("|", ss.Region, "|", ss.SnapShot.SnapShot, "|", ss.Name, "|", instance, "|", ss.DiskId, "|", ss.DiskSize, "GB |", ss.CreatedTime.Format("2006-01-02 15:04:05"), "|", ss.State, "|", ss.LatestOperation, ss.LatestOperationState, "|")
+		fmt.Println("------------------------------------------")
+	}
+
+	fmt.Println("已经销毁的实例留下的快照可以通过 lhbin ss del --region region --orphaned 命令删除")
+	return nil
+}
+
 func DescribeSnapshots() error {
 
 	var region string
@@ -76,6 +147,11 @@ func DescribeSnapshots() error {
 	fmt.Println("| 快照名称 | ", snapshot.Name, "|")
 	fmt.Println("| 状态 | ", snapshot.State, "|")
 	fmt.Println("| 进度 | ", snapshot.Percent, "|")
+	fmt.Println("| 磁盘ID | ", snapshot.DiskId, "|")
+	fmt.Println("| 磁盘类型 | ", snapshot.DiskUsage, "|")
+	fmt.Println("| 磁盘大小 | ", snapshot.DiskSize, "GB |")
+	fmt.Println("| 最新操作 | ", snapshot.LatestOperation, "|")
+	fmt.Println("| 最新操作状态 | ", snapshot.LatestOperationState, "|")
 	fmt.Println("| 创建时间 | ", snapshot.CreatedTime.Format("2006-01-02 15:04:05"), "|")
 	fmt.Println("-------------------------------")
 
@@ -88,17 +164,21 @@ func DeleteSnapshots() error {
 	var region string
 	var snapshotID string
 	var snapshotIDs string
+	var orphaned bool
 
 	cdriver, err := parseAndGetDriver(func() {
 		flag.StringVar(&region, "region", "", "地域，必须填写")
 		flag.StringVar(&snapshotID, "ssid", "", "快照ID,如果填写此项，则忽略ssids参数的值")
 		flag.StringVar(&snapshotIDs, "ssids", "", "快照ID列表，用逗号隔开")
+		flag.BoolVar(&orphaned, "orphaned", false, "删除地域下所有已经销毁的实例留下的快照，设置后会忽略ssid和ssids参数")
 	}, func() error {
 		if snapshotID != "" {
 			snapshotIDs = snapshotID
 		}
 		checkArg(&region, "地域不能为空")
-		checkArg(&snapshotIDs, "快照ID不能为空")
+		if !orphaned {
+			checkArg(&snapshotIDs, "快照ID不能为空")
+		}
 		return nil
 	}, os.Args[3:])
 
@@ -107,8 +187,33 @@ func DeleteSnapshots() error {
 	}
 
 	ssids := strings.Split(snapshotIDs, ",")
+	if orphaned {
+		snapshots, err := cdriver.ListSnapshots(region, nil)
+		if err != nil {
+			return err
+		}
+		ssids = []string{}
+		for _, snapshot := range snapshots {
+			if snapshot.InstanceId == "" {
+				fmt.Printf("%s地域的快照%s(%s)所属的实例已经销毁，创建时间%s\n", region, snapshot.Name, snapshot.SnapShot, snapshot.CreatedTime.Format("2006-01-02 15:04:05"))
+				ssids = append(ssids, snapshot.SnapShot)
+			}
+		}
+		if len(ssids) == 0 {
+			fmt.Printf("%s地域下没有已经销毁的实例留下的快照\n", region)
+			return nil
+		}
+	}
+
+	// 先列出要删除的快照再确认，--orphaned时可以看到具体会删除哪些快照
+	if !confirmRisk(fmt.Sprintf("将删除%s地域的%d个快照", region, len(ssids))) {
+		return nil
+	}
 
 	for _, ssid := range ssids {
+		if planned("将删除%s地域的快照%s", region, ssid) {
+			continue
+		}
 		err := cdriver.DeleteSnapshots(region, []string{ssid})
 		if err != nil {
 			fmt.Printf("%s地域的快照%s删除失败，原因是:%s \n", region, ssid, err.Error())
@@ -156,6 +261,10 @@ func ApplySnapshot() error {
 		return err
 	}
 
+	if planned("将把%s地域的实例%s恢复到快照%s", region, insid, snapshotID) {
+		return nil
+	}
+
 	err = cdriver.ApplySnapshot(region, insid, snapshotID)
 	if err != nil {
 		fmt.Printf("%s地域的实例%s恢复快照%s失败，原因:%s\n", region, insid, snapshotID, err.Error())
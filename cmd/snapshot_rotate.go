@@ -0,0 +1,215 @@
+package cmd
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lixiaofei123/lhbin/config"
+	"github.com/lixiaofei123/lhbin/driver"
+)
+
+const defaultRotatePrefix string = "lhbin-rotate-"
+
+func init() {
+	RegisterChildCommandOperator(SnapshotCommandName, "rotate", "为符合条件的实例创建新的快照，并按照保留策略删除旧的快照", []string{}, RiskOperation("不在保留策略中的旧快照会被删除", RotateSnapshots))
+}
+
+// snapshotRetention 快照保留策略，三个条件之间是或的关系，满足任意一个条件的快照都会被保留
+type snapshotRetention struct {
+	keepLast   int
+	keepDaily  int
+	keepWeekly int
+}
+
+func (retention *snapshotRetention) empty() bool {
+	return retention.keepLast <= 0 && retention.keepDaily <= 0 && retention.keepWeekly <= 0
+}
+
+// keep 返回需要保留的快照ID。keepDaily和keepWeekly保留每天、每周最新的一个快照
+func (retention *snapshotRetention) keep(snapshots []*driver.SnapShot) map[string]bool {
+	sorted := make([]*driver.SnapShot, len(snapshots))
+	copy(sorted, snapshots)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].CreatedTime.After(sorted[j].CreatedTime)
+	})
+
+	kept := map[string]bool{}
+	for index, snapshot := range sorted {
+		if index < retention.keepLast {
+			kept[snapshot.SnapShot] = true
+		}
+	}
+
+	keepPeriods := func(count int, period func(t time.Time) string) {
+		seen := map[string]bool{}
+		for _, snapshot := range sorted {
+			if len(seen) >= count {
+				return
+			}
+			key := period(snapshot.CreatedTime.Local())
+			if !seen[key] {
+				seen[key] = true
+				kept[snapshot.SnapShot] = true
+			}
+		}
+	}
+
+	keepPeriods(retention.keepDaily, func(t time.Time) string {
+		return t.Format("2006-01-02")
+	})
+	keepPeriods(retention.keepWeekly, func(t time.Time) string {
+		year, week := t.ISOWeek()
+		return fmt.Sprintf("%d-%d", year, week)
+	})
+
+	return kept
+}
+
+// logFunc 输出操作过程，命令行下打印到终端，daemon中写入任务日志
+type logFunc func(format string, a ...interface{})
+
+func stdoutLog(format string, a ...interface{}) {
+	fmt.Printf(format, a...)
+}
+
+func writerLog(output io.Writer) logFunc {
+	return func(format string, a ...interface{}) {
+		fmt.Fprintf(output, format, a...)
+	}
+}
+
+// waitSnapshotNormal 等待快照创建完成，快照状态不再是创建中时返回
+func waitSnapshotNormal(cdriver driver.Driver, region, snapshotID string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		snapshot, err := cdriver.SnapshotInfo(region, snapshotID)
+		if err == nil {
+			switch snapshot.State {
+			case driver.SnapShotNormal:
+				return nil
+			case driver.SnapShotCreating:
+			default:
+				return fmt.Errorf("快照%s的状态为%s", snapshotID, snapshot.State)
+			}
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("等待快照%s创建完成超时", snapshotID)
+		}
+		time.Sleep(10 * time.Second)
+	}
+}
+
+func RotateSnapshots() error {
+
+	var prefix string
+	var timeout time.Duration
+	retention := &snapshotRetention{}
+
+	flag.StringVar(&prefix, "prefix", defaultRotatePrefix, "快照名称前缀，新快照的名称为前缀加上创建时间，只有带有此前缀的快照才会被删除")
+	flag.IntVar(&retention.keepLast, "keep-last", 3, "保留最新的N个快照")
+	flag.IntVar(&retention.keepDaily, "keep-daily", 0, "保留最近D天中每天最新的一个快照")
+	flag.IntVar(&retention.keepWeekly, "keep-weekly", 0, "保留最近W周中每周最新的一个快照")
+	flag.DurationVar(&timeout, "timeout", 30*time.Minute, "等待新快照创建完成的最长时间，超时后不会删除旧快照")
+
+	var lock sync.Mutex
+	failed := []string{}
+
+	err := runBatchOperatorInstances(true, config.ActiveProfile().Parallelism, func(region string, insids string) error {
+		checkArg(&prefix, "快照名称前缀不能为空")
+		if retention.empty() {
+			return errors.New("keep-last、keep-daily、keep-weekly至少需要设置一个大于0的值")
+		}
+		return nil
+	}, func(cdriver driver.Driver, region, name, insid string, args ...interface{}) {
+		err := rotateInstanceSnapshots(cdriver, region, insid, prefix, retention, timeout, writerLog(outputOf(args)))
+		if err != nil {
+			fmt.Fprintf(outputOf(args), "%s地域的实例%s(%s)快照轮转失败，原因是:%s \n", region, name, insid, err.Error())
+			lock.Lock()
+			failed = append(failed, insid)
+			lock.Unlock()
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("以下实例快照轮转失败:%s", strings.Join(failed, ","))
+	}
+	return nil
+}
+
+func rotateInstanceSnapshots(cdriver driver.Driver, region, insid, prefix string, retention *snapshotRetention, timeout time.Duration, logf logFunc) error {
+
+	snapshots, err := cdriver.ListSnapshots(region, &driver.SnapshotFilter{InstanceId: insid})
+	if err != nil {
+		return err
+	}
+
+	managed := []*driver.SnapShot{}
+	for _, snapshot := range snapshots {
+		if strings.HasPrefix(snapshot.Name, prefix) {
+			managed = append(managed, snapshot)
+		}
+	}
+
+	// 新快照一定是最新的，先按照包含新快照的情况计算需要删除的快照
+	now := time.Now()
+	newSnapshot := &driver.SnapShot{SnapShot: "new", Name: prefix + now.Format("20060102-150405"), CreatedTime: now}
+	kept := retention.keep(append([]*driver.SnapShot{newSnapshot}, managed...))
+
+	expired := []*driver.SnapShot{}
+	for _, snapshot := range managed {
+		if !kept[snapshot.SnapShot] && snapshot.State == driver.SnapShotNormal {
+			expired = append(expired, snapshot)
+		}
+	}
+	sort.SliceStable(expired, func(i, j int) bool {
+		return expired[i].CreatedTime.Before(expired[j].CreatedTime)
+	})
+
+	if plannedTo(logf, "将为%s地域的实例%s创建快照%s", region, insid, newSnapshot.Name) {
+		for _, snapshot := range expired {
+			plannedTo(logf, "将删除%s地域的快照%s(%s)，创建时间%s", region, snapshot.Name, snapshot.SnapShot, snapshot.CreatedTime.Format("2006-01-02 15:04:05"))
+		}
+		return nil
+	}
+
+	// 配额不足时直接失败，不在新快照创建成功之前删除旧快照，避免创建失败后可用的快照反而变少
+	quota, err := cdriver.ResourceQuota(region, driver.SnapshotQuota)
+	if err != nil {
+		return err
+	}
+	if quota.Available <= 0 {
+		return fmt.Errorf("快照配额已用完(共%d个)，请先手动删除不需要的快照或者调小保留数量", quota.Total)
+	}
+
+	created, err := cdriver.CreateSnapshot(region, insid, newSnapshot.Name)
+	if err != nil {
+		return err
+	}
+	logf("%s地域的实例%s已开始创建快照%s(%s)，等待创建完成\n", region, insid, newSnapshot.Name, created.SnapShot)
+
+	if err := waitSnapshotNormal(cdriver, region, created.SnapShot, timeout); err != nil {
+		return fmt.Errorf("%s，没有删除旧快照", err.Error())
+	}
+	logf("%s地域的快照%s(%s)创建完成\n", region, newSnapshot.Name, created.SnapShot)
+
+	for _, snapshot := range expired {
+		err := cdriver.DeleteSnapshots(region, []string{snapshot.SnapShot})
+		if err != nil {
+			logf("%s地域的快照%s(%s)删除失败，原因是:%s \n", region, snapshot.Name, snapshot.SnapShot, err.Error())
+		} else {
+			logf("%s地域的快照%s(%s)删除成功 \n", region, snapshot.Name, snapshot.SnapShot)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,108 @@
+package cmd
+
+import (
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lixiaofei123/lhbin/driver"
+)
+
+// rotateSnapshots 按照"2006-01-02 15:04"格式的创建时间生成快照，快照ID即为创建时间
+func rotateSnapshots(times ...string) []*driver.SnapShot {
+	snapshots := []*driver.SnapShot{}
+	for _, t := range times {
+		created, err := time.ParseInLocation("2006-01-02 15:04", t, time.Local)
+		if err != nil {
+			panic(err)
+		}
+		snapshots = append(snapshots, &driver.SnapShot{SnapShot: t, CreatedTime: created, State: driver.SnapShotNormal})
+	}
+	return snapshots
+}
+
+func TestSnapshotRetentionKeep(t *testing.T) {
+	daily := rotateSnapshots("2024-03-01 08:00", "2024-03-03 09:00", "2024-03-01 10:00", "2024-03-02 12:00")
+
+	// 2024-12-30(周一)和2025-01-01属于2025年第1周，2024-12-29(周日)属于2024年第52周
+	yearEnd := rotateSnapshots("2025-01-01 10:00", "2024-12-30 10:00", "2024-12-29 10:00", "2024-12-23 10:00", "2024-12-22 10:00")
+
+	// 2020-12-31和2021-01-03跨年但都属于2020年第53周，2021-01-04属于2021年第1周
+	week53 := rotateSnapshots("2020-12-31 10:00", "2021-01-03 10:00", "2021-01-04 10:00")
+
+	cases := []struct {
+		name      string
+		retention snapshotRetention
+		snapshots []*driver.SnapShot
+		kept      []string
+	}{
+		{
+			name:      "只保留最新的N个",
+			retention: snapshotRetention{keepLast: 2},
+			snapshots: daily,
+			kept:      []string{"2024-03-02 12:00", "2024-03-03 09:00"},
+		},
+		{
+			name:      "数量超过快照总数",
+			retention: snapshotRetention{keepLast: 10},
+			snapshots: daily,
+			kept:      []string{"2024-03-01 08:00", "2024-03-01 10:00", "2024-03-02 12:00", "2024-03-03 09:00"},
+		},
+		{
+			name:      "每天保留最新的一个",
+			retention: snapshotRetention{keepDaily: 3},
+			snapshots: daily,
+			kept:      []string{"2024-03-01 10:00", "2024-03-02 12:00", "2024-03-03 09:00"},
+		},
+		{
+			name:      "最新的N个和每天重叠",
+			retention: snapshotRetention{keepLast: 1, keepDaily: 2},
+			snapshots: daily,
+			kept:      []string{"2024-03-02 12:00", "2024-03-03 09:00"},
+		},
+		{
+			name:      "最新的N个包含同一天的旧快照",
+			retention: snapshotRetention{keepLast: 3, keepDaily: 1},
+			snapshots: daily,
+			kept:      []string{"2024-03-01 10:00", "2024-03-02 12:00", "2024-03-03 09:00"},
+		},
+		{
+			name:      "跨年的ISO周",
+			retention: snapshotRetention{keepWeekly: 2},
+			snapshots: yearEnd,
+			kept:      []string{"2024-12-29 10:00", "2025-01-01 10:00"},
+		},
+		{
+			name:      "跨年的ISO周保留三周",
+			retention: snapshotRetention{keepWeekly: 3},
+			snapshots: yearEnd,
+			kept:      []string{"2024-12-22 10:00", "2024-12-29 10:00", "2025-01-01 10:00"},
+		},
+		{
+			name:      "每天和每周重叠",
+			retention: snapshotRetention{keepDaily: 2, keepWeekly: 2},
+			snapshots: yearEnd,
+			kept:      []string{"2024-12-29 10:00", "2024-12-30 10:00", "2025-01-01 10:00"},
+		},
+		{
+			name:      "第53周跨年",
+			retention: snapshotRetention{keepWeekly: 2},
+			snapshots: week53,
+			kept:      []string{"2021-01-03 10:00", "2021-01-04 10:00"},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			kept := []string{}
+			for id := range c.retention.keep(c.snapshots) {
+				kept = append(kept, id)
+			}
+			sort.Strings(kept)
+			if strings.Join(kept, ",") != strings.Join(c.kept, ",") {
+				t.Fatalf("保留的快照为%v，期望为%v", kept, c.kept)
+			}
+		})
+	}
+}
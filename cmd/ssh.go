@@ -0,0 +1,216 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lixiaofei123/lhbin/config"
+	"github.com/lixiaofei123/lhbin/driver"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// sshOptions ssh连接时可以通过参数覆盖的配置，为空时自动推断
+type sshOptions struct {
+	user      string
+	keyPath   string
+	port      int
+	timeout   time.Duration
+	acceptNew bool
+}
+
+// sshHost 一台可以通过ssh连接的实例
+type sshHost struct {
+	target *instanceTarget
+	ip     string
+	user   string
+	signer ssh.Signer
+}
+
+// defaultSSHUser 根据实例的操作系统推断默认的登录用户，腾讯云的Ubuntu镜像默认用户为ubuntu，其它Linux镜像为root
+func defaultSSHUser(info *driver.InstanceInfo) string {
+	if strings.Contains(strings.ToLower(info.OSName), "ubuntu") {
+		return "ubuntu"
+	}
+	return "root"
+}
+
+var signerCache = map[string]ssh.Signer{}
+var signerMutex sync.Mutex
+
+// loadSigner 读取私钥文件，私钥加密时从终端读取密码，同一个文件只会读取一次
+func loadSigner(path string) (ssh.Signer, error) {
+	signerMutex.Lock()
+	defer signerMutex.Unlock()
+
+	if signer, ok := signerCache[path]; ok {
+		return signer, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	signer, err := ssh.ParsePrivateKey(data)
+	var missingErr *ssh.PassphraseMissingError
+	if errors.As(err, &missingErr) {
+		passphrase := readPassword(fmt.Sprintf("请输入私钥%s的密码:", path))
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(data, []byte(passphrase))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("读取私钥%s失败:%s", path, err.Error())
+	}
+
+	signerCache[path] = signer
+	return signer, nil
+}
+
+// resolveKeyPath 在本地密钥登记文件中查找实例绑定的密钥对对应的私钥文件
+func resolveKeyPath(cdriver driver.Driver, region, insid string) (string, error) {
+	kps, err := cdriver.ListKeyPair(region)
+	if err != nil {
+		return "", err
+	}
+
+	bound := []string{}
+	for _, kp := range kps {
+		for _, instanceID := range kp.AssociatedInstanceIds {
+			if instanceID != insid {
+				continue
+			}
+			bound = append(bound, kp.KeyId)
+			keyFile, err := config.FindKeyFile(kp.KeyId)
+			if err != nil {
+				continue
+			}
+			if _, err := os.Stat(keyFile.Path); err == nil {
+				return keyFile.Path, nil
+			}
+		}
+	}
+
+	if len(bound) == 0 {
+		return "", fmt.Errorf("实例%s没有绑定密钥对，请通过--key参数指定私钥文件", insid)
+	}
+	return "", fmt.Errorf("实例%s绑定的密钥对%s在本地没有登记私钥文件，请通过--key参数指定私钥文件", insid, strings.Join(bound, ","))
+}
+
+// resolveSSHHost 查询实例的公网IP、登录用户和私钥
+func resolveSSHHost(target *instanceTarget, options *sshOptions) (*sshHost, error) {
+	info, err := target.cdriver.InstanceInfo(target.region, target.insid)
+	if err != nil {
+		return nil, err
+	}
+
+	if driver.PlatformType(info.PlatformType) == driver.WinPlatform {
+		return nil, fmt.Errorf("实例%s为Windows系统，不支持ssh连接", target.insid)
+	}
+	if info.PublicIP == "" {
+		return nil, fmt.Errorf("实例%s没有公网IP", target.insid)
+	}
+
+	user := options.user
+	if user == "" {
+		user = defaultSSHUser(info)
+	}
+
+	keyPath := options.keyPath
+	if keyPath == "" {
+		keyPath, err = resolveKeyPath(target.cdriver, target.region, target.insid)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		keyPath, err = expandPath(keyPath)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	signer, err := loadSigner(keyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sshHost{target: target, ip: info.PublicIP, user: user, signer: signer}, nil
+}
+
+var knownHostsMutex sync.Mutex
+
+// hostKeyCallback 使用配置目录下的known_hosts文件校验主机公钥，公钥变化时拒绝连接。第一次连接的主机会显示公钥指纹，
+// 确认后记录到known_hosts中，acceptNew为true时不再询问直接记录
+func hostKeyCallback(acceptNew bool) (ssh.HostKeyCallback, error) {
+	dir, err := config.ConfigDir()
+	if err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir, "known_hosts")
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	f.Close()
+
+	callback, err := knownhosts.New(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		err := callback(hostname, remote, key)
+		if err == nil {
+			return nil
+		}
+		var keyErr *knownhosts.KeyError
+		if !errors.As(err, &keyErr) {
+			return err
+		}
+		if len(keyErr.Want) > 0 {
+			return fmt.Errorf("主机%s的公钥和%s中记录的不一致，可能存在中间人攻击。如果实例已经重装，请删除%s中对应的记录", hostname, path, path)
+		}
+
+		// 同时连接多台主机时逐个询问，避免提示信息交错
+		knownHostsMutex.Lock()
+		defer knownHostsMutex.Unlock()
+
+		fmt.Printf("主机%s的公钥没有记录在%s中，公钥类型为%s，SHA256指纹为%s\n", hostname, path, key.Type(), ssh.FingerprintSHA256(key))
+		if !acceptNew {
+			if !isTerminal() {
+				return fmt.Errorf("无法确认主机%s的公钥，请在终端中运行或者加上--accept-new参数", hostname)
+			}
+			fmt.Print("请输入Y来信任此公钥并记录（不区分大小写，输入其他任意字符取消连接）:")
+			if strings.ToLower(readLine()) != "y" {
+				return fmt.Errorf("没有信任主机%s的公钥，已取消连接", hostname)
+			}
+		}
+
+		f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = fmt.Fprintln(f, knownhosts.Line([]string{knownhosts.Normalize(hostname)}, key))
+		return err
+	}, nil
+}
+
+func dialSSH(host *sshHost, options *sshOptions, callback ssh.HostKeyCallback) (*ssh.Client, error) {
+	return ssh.Dial("tcp", net.JoinHostPort(host.ip, fmt.Sprintf("%d", options.port)), &ssh.ClientConfig{
+		User:            host.user,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(host.signer)},
+		HostKeyCallback: callback,
+		Timeout:         options.timeout,
+	})
+}
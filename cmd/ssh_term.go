@@ -0,0 +1,43 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"golang.org/x/term"
+)
+
+// isTerminal 判断标准输入是否为终端
+func isTerminal() bool {
+	return term.IsTerminal(int(os.Stdin.Fd()))
+}
+
+// makeRawTerminal 把终端切换为raw模式，返回恢复终端的函数
+func makeRawTerminal() (func(), error) {
+	fd := int(os.Stdin.Fd())
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		return nil, err
+	}
+	return func() { term.Restore(fd, state) }, nil
+}
+
+// terminalSize 返回终端的行数和列数，查询失败时返回24行80列
+func terminalSize() (int, int) {
+	cols, rows, err := term.GetSize(int(os.Stdout.Fd()))
+	if err == nil && rows > 0 && cols > 0 {
+		return rows, cols
+	}
+	return 24, 80
+}
+
+// readPassword 读取密码，标准输入为终端时不回显，否则按行读取，方便通过管道传入
+func readPassword(prompt string) string {
+	fmt.Print(prompt)
+	if !isTerminal() {
+		return readLine()
+	}
+	password, _ := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Println()
+	return string(password)
+}
@@ -15,11 +15,14 @@ func init() {
 }
 
 func ListTrafficPackages() error {
-	fmt.Println("--------------------------------------")
-	fmt.Println("| 地域 | 实例ID | 总流量 | 已用流量 | 剩余流量 |")
-	fmt.Println("------------------------------------------")
-	return baseBatchOperatorInstances(false, func(region string, insids string) error { return nil }, func(cdriver driver.Driver, region, name, insid string, args ...interface{}) {
+	return baseBatchOperatorInstances(false, func(region string, insids string) error {
+		fmt.Println("--------------------------------------")
+		fmt.Println(accountHeader() + "| 地域 | 实例ID | 总流量 | 已用流量 | 剩余流量 |")
+		fmt.Println("------------------------------------------")
+		return nil
+	}, func(cdriver driver.Driver, region, name, insid string, args ...interface{}) {
 		tps, err := cdriver.InstancesTrafficPackages(region, []string{insid})
+		fmt.Print(accountColumn(accountOf(args)))
 		if err != nil {
 			fmt.Printf("|%s|%s(%s)|查询失败，原因:%s|\n", region, name, insid, err.Error())
 		} else {
@@ -2,10 +2,10 @@ package config
 
 import (
 	"errors"
+	"fmt"
 	"io/ioutil"
-	"log"
 	"os"
-	"path"
+	"path/filepath"
 
 	"gopkg.in/yaml.v2"
 )
@@ -16,104 +16,231 @@ const (
 	QQCloud DriverName = "qqcloud"
 )
 
-var GlobalConfig *Config
+const ConfigPathEnv string = "LHBIN_CONFIG"
+
+// GlobalConfig 在调用Load之前为空配置，保证查看帮助信息时不需要读取配置文件
+var GlobalConfig *Config = newConfig()
 var configFilePath string
+var loaded bool
+
+type Config struct {
+	Version        int                       `yaml:"version"`
+	CurrentProfile string                    `yaml:"current_profile,omitempty"`
+	Profiles       map[string]*ProfileConfig `yaml:"profiles,omitempty"`
+	Accounts       []*AccountConfig          `yaml:"accounts"`
+}
+
+type AccountConfig struct {
+	Driver   DriverName `yaml:"driver"`
+	Account  string     `yaml:"account"`
+	AKID     string     `yaml:"akid"`
+	AKSecret string     `yaml:"aksecret"`
+	Region   string     `yaml:"region,omitempty"` // 该账户的默认地域
+}
+
+func newConfig() *Config {
+	return &Config{Version: CurrentVersion}
+}
+
+// SetConfigPath 指定配置文件路径，需要在Load之前调用
+func SetConfigPath(path string) {
+	configFilePath = path
+}
+
+// ConfigPath 返回配置文件路径，优先级为 SetConfigPath > 环境变量LHBIN_CONFIG > ~/.lhbin/config.yaml
+func ConfigPath() (string, error) {
+	if configFilePath != "" {
+		return configFilePath, nil
+	}
+	if path := os.Getenv(ConfigPathEnv); path != "" {
+		return path, nil
+	}
 
-func init() {
 	homedir, err := os.UserHomeDir()
 	if err != nil {
-		log.Panic(err)
+		return "", err
+	}
+	return filepath.Join(homedir, ".lhbin", "config.yaml"), nil
+}
+
+// ConfigDir 返回配置文件所在的目录，模板等其它本地数据也保存在此目录下
+func ConfigDir() (string, error) {
+	path, err := ConfigPath()
+	if err != nil {
+		return "", err
 	}
+	return filepath.Dir(path), nil
+}
 
-	configDir := path.Join(homedir, ".lhbin")
+// Load 读取配置文件，只会读取一次。配置文件不存在时使用空配置，等到第一次修改配置时才会创建
+func Load() error {
+	if loaded {
+		return nil
+	}
 
-	_, err = os.Stat(configDir)
-	if os.IsNotExist(err) {
-		err = os.Mkdir(configDir, 0700)
+	path, err := ConfigPath()
+	if err != nil {
+		return err
 	}
 
+	c, err := readConfig(path)
 	if err != nil {
-		log.Panic(err)
+		return err
 	}
 
-	GlobalConfig = new(Config)
+	GlobalConfig = c
+	loaded = true
+	return nil
+}
 
-	configFilePath = path.Join(configDir, "config.yaml")
-	_, err = os.Stat(configFilePath)
+func readConfig(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
 	if os.IsNotExist(err) {
+		return newConfig(), nil
+	}
+	if err != nil {
+		return nil, err
+	}
 
-		data, _ := yaml.Marshal(GlobalConfig)
-		err = ioutil.WriteFile(configFilePath, data, 0660)
+	c := &Config{}
+	if err := yaml.Unmarshal(data, c); err != nil {
+		return nil, fmt.Errorf("配置文件%s格式错误:%s", path, err.Error())
 	}
 
-	if err != nil {
-		log.Panic(err)
+	if err := migrate(c); err != nil {
+		return nil, fmt.Errorf("配置文件%s升级失败:%s", path, err.Error())
 	}
 
-	data, err := ioutil.ReadFile(configFilePath)
-	if err != nil {
-		log.Panic(err)
+	if err := c.Validate(); err != nil {
+		return nil, fmt.Errorf("配置文件%s校验失败:%s", path, err.Error())
 	}
 
-	err = yaml.Unmarshal(data, GlobalConfig)
+	return c, nil
+}
+
+// update 在文件锁的保护下重新读取配置文件，执行修改后写回，避免多个进程同时修改时互相覆盖
+func update(modify func() error) error {
+	path, err := ConfigPath()
 	if err != nil {
-		log.Panic(err)
+		return err
 	}
 
-}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
 
-type Config struct {
-	Accounts []*AccountConfig `yaml:"accounts"`
-}
+	unlock, err := lockFile(path + ".lock")
+	if err != nil {
+		return err
+	}
+	defer unlock()
 
-type AccountConfig struct {
-	Driver   DriverName `yaml:"driver"`
-	Account  string     `yaml:"account"`
-	AKID     string     `yaml:"akid"`
-	AKSecret string     `yaml:"aksecret"`
-}
+	c, err := readConfig(path)
+	if err != nil {
+		return err
+	}
+	GlobalConfig = c
+	loaded = true
 
-func AddAccount(newAccount *AccountConfig) {
-	find := false
-	for index, account := range GlobalConfig.Accounts {
-		if account.Account == newAccount.Account && account.Driver == newAccount.Driver {
-			GlobalConfig.Accounts[index] = newAccount
-			find = true
-			break
-		}
+	if err := modify(); err != nil {
+		return err
 	}
 
-	if !find {
-		GlobalConfig.Accounts = append(GlobalConfig.Accounts, newAccount)
+	if err := GlobalConfig.Validate(); err != nil {
+		return err
 	}
 
 	data, err := yaml.Marshal(GlobalConfig)
 	if err != nil {
-		log.Panic(err)
+		return err
 	}
-	err = ioutil.WriteFile(configFilePath, data, 0660)
+	return writeFileAtomic(path, data, 0600)
+}
+
+// writeFileAtomic 先写入同目录下的临时文件再重命名，避免写入过程中断导致配置文件损坏
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
 	if err != nil {
-		log.Panic(err)
+		return err
+	}
+	tmpPath := tmp.Name()
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Chmod(tmpPath, perm); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return err
 	}
+	return nil
+}
+
+func AddAccount(newAccount *AccountConfig) error {
+	return update(func() error {
+		find := false
+		for index, account := range GlobalConfig.Accounts {
+			if account.Account == newAccount.Account && account.Driver == newAccount.Driver {
+				GlobalConfig.Accounts[index] = newAccount
+				find = true
+				break
+			}
+		}
 
+		if !find {
+			GlobalConfig.Accounts = append(GlobalConfig.Accounts, newAccount)
+		}
+		return nil
+	})
 }
 
-func DeleteAccount(driver DriverName, delAccount string) {
-	for index, account := range GlobalConfig.Accounts {
-		if account.Account == delAccount && account.Driver == driver {
-			GlobalConfig.Accounts = append(GlobalConfig.Accounts[0:index], GlobalConfig.Accounts[index+1:]...)
-			break
+func DeleteAccount(driver DriverName, delAccount string) error {
+	return update(func() error {
+		for index, account := range GlobalConfig.Accounts {
+			if account.Account == delAccount && account.Driver == driver {
+				GlobalConfig.Accounts = append(GlobalConfig.Accounts[0:index], GlobalConfig.Accounts[index+1:]...)
+				break
+			}
 		}
-	}
-	data, _ := yaml.Marshal(GlobalConfig)
-	err := ioutil.WriteFile(configFilePath, data, 0660)
-	if err != nil {
-		log.Panic(err)
-	}
 
+		for _, profile := range GlobalConfig.Profiles {
+			if profile.Account == delAccount {
+				profile.Account = ""
+			}
+		}
+		return nil
+	})
+}
+
+func FindAccounts(driver DriverName) []*AccountConfig {
+	accounts := []*AccountConfig{}
+	for _, account := range GlobalConfig.Accounts {
+		if account.Driver == driver {
+			accounts = append(accounts, account)
+		}
+	}
+	return accounts
 }
 
 func FindAcount(driver DriverName, findAccount string) (*AccountConfig, error) {
+	if findAccount == "" {
+		findAccount = ActiveProfile().Account
+	}
 	for _, account := range GlobalConfig.Accounts {
 		if account.Driver == driver {
 			if findAccount == "" {
@@ -0,0 +1,135 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"gopkg.in/yaml.v2"
+)
+
+// KeyFile 记录密钥对和本地私钥文件的对应关系
+type KeyFile struct {
+	KeyId     string `yaml:"keyid"`
+	KeyName   string `yaml:"keyname"`
+	Account   string `yaml:"account,omitempty"`
+	Region    string `yaml:"region,omitempty"`
+	Path      string `yaml:"path"`
+	Encrypted bool   `yaml:"encrypted,omitempty"`
+}
+
+type keyFiles struct {
+	Keys []*KeyFile `yaml:"keys"`
+}
+
+func keyFilesPath() (string, error) {
+	dir, err := ConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "keys.yaml"), nil
+}
+
+func readKeyFiles(path string) (*keyFiles, error) {
+	keys := &keyFiles{}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return keys, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(data, keys); err != nil {
+		return nil, fmt.Errorf("密钥登记文件%s格式错误:%s", path, err.Error())
+	}
+	return keys, nil
+}
+
+func updateKeyFiles(modify func(keys *keyFiles) error) error {
+	path, err := keyFilesPath()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	unlock, err := lockFile(path + ".lock")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	keys, err := readKeyFiles(path)
+	if err != nil {
+		return err
+	}
+
+	if err := modify(keys); err != nil {
+		return err
+	}
+
+	data, err := yaml.Marshal(keys)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data, 0600)
+}
+
+// ListKeyFiles 返回按密钥ID排序的所有本地私钥登记信息
+func ListKeyFiles() ([]*KeyFile, error) {
+	path, err := keyFilesPath()
+	if err != nil {
+		return nil, err
+	}
+	keys, err := readKeyFiles(path)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(keys.Keys, func(i, j int) bool {
+		return keys.Keys[i].KeyId < keys.Keys[j].KeyId
+	})
+	return keys.Keys, nil
+}
+
+func FindKeyFile(keyId string) (*KeyFile, error) {
+	keys, err := ListKeyFiles()
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range keys {
+		if key.KeyId == keyId {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("密钥对%s没有登记本地私钥文件", keyId)
+}
+
+// SaveKeyFile 登记密钥对的本地私钥文件，同一个密钥ID的登记信息会被覆盖
+func SaveKeyFile(newKey *KeyFile) error {
+	return updateKeyFiles(func(keys *keyFiles) error {
+		for index, key := range keys.Keys {
+			if key.KeyId == newKey.KeyId {
+				keys.Keys[index] = newKey
+				return nil
+			}
+		}
+		keys.Keys = append(keys.Keys, newKey)
+		return nil
+	})
+}
+
+func DeleteKeyFile(keyId string) error {
+	return updateKeyFiles(func(keys *keyFiles) error {
+		for index, key := range keys.Keys {
+			if key.KeyId == keyId {
+				keys.Keys = append(keys.Keys[0:index], keys.Keys[index+1:]...)
+				return nil
+			}
+		}
+		return fmt.Errorf("密钥对%s没有登记本地私钥文件", keyId)
+	})
+}
@@ -0,0 +1,40 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+const (
+	lockRetryInterval = 50 * time.Millisecond
+	lockTimeout       = 10 * time.Second
+	lockStaleAfter    = time.Minute
+)
+
+// lockFile 通过独占创建锁文件实现跨进程的互斥，不依赖具体平台的文件锁接口。
+// 超过lockStaleAfter仍未释放的锁文件视为进程异常退出后的残留，会被清理
+func lockFile(lockPath string) (func(), error) {
+	deadline := time.Now().Add(lockTimeout)
+	for {
+		f, err := os.OpenFile(lockPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err == nil {
+			fmt.Fprintf(f, "%d", os.Getpid())
+			f.Close()
+			return func() { os.Remove(lockPath) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+
+		if info, err := os.Stat(lockPath); err == nil && time.Since(info.ModTime()) > lockStaleAfter {
+			os.Remove(lockPath)
+			continue
+		}
+
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("等待配置文件锁%s超时，如果没有其它lhbin进程在运行，请手动删除该文件", lockPath)
+		}
+		time.Sleep(lockRetryInterval)
+	}
+}
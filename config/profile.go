@@ -0,0 +1,216 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	DefaultProfileName string = "default"
+
+	ProfileEnv string = "LHBIN_PROFILE"
+	RegionEnv  string = "LHBIN_REGION"
+)
+
+type OutputFormat string
+
+const (
+	TableOutput OutputFormat = "table"
+	JsonOutput  OutputFormat = "json"
+)
+
+// ConfirmPolicy 决定风险操作和危险操作是否需要用户确认
+type ConfirmPolicy string
+
+const (
+	ConfirmAll    ConfirmPolicy = "all"    // 风险操作和危险操作都需要确认
+	ConfirmDanger ConfirmPolicy = "danger" // 仅危险操作需要确认
+	ConfirmNone   ConfirmPolicy = "none"   // 所有操作都不需要确认
+)
+
+type ProfileConfig struct {
+	Account     string        `yaml:"account,omitempty"`       // 默认账户
+	Output      OutputFormat  `yaml:"output,omitempty"`        // 默认输出格式
+	Parallelism int           `yaml:"parallelism,omitempty"`   // 批量操作的并发数
+	Confirm     ConfirmPolicy `yaml:"confirm,omitempty"`       // 确认策略
+	IpDetectURL string        `yaml:"ip-detect-url,omitempty"` // 查询本机公网IP的地址，firewall allow-me使用
+}
+
+// 可以通过 config get/set 读写的配置项
+var ProfileKeys = []string{"account", "region", "output", "parallelism", "confirm", "ip-detect-url"}
+
+// DefaultIpDetectURL 返回调用方公网IP的默认地址，响应内容为纯文本的IP
+const DefaultIpDetectURL string = "https://api.ipify.org"
+
+// ActiveProfileName 返回当前生效的配置名称，环境变量LHBIN_PROFILE优先
+func ActiveProfileName() string {
+	if name := os.Getenv(ProfileEnv); name != "" {
+		return name
+	}
+	if GlobalConfig.CurrentProfile != "" {
+		return GlobalConfig.CurrentProfile
+	}
+	return DefaultProfileName
+}
+
+// ActiveProfile 返回当前生效的配置，未设置的项会填充默认值
+func ActiveProfile() *ProfileConfig {
+	return GetProfile(ActiveProfileName())
+}
+
+func GetProfile(name string) *ProfileConfig {
+	profile := &ProfileConfig{}
+	if p, ok := GlobalConfig.Profiles[name]; ok && p != nil {
+		*profile = *p
+	}
+
+	if profile.Output == "" {
+		profile.Output = TableOutput
+	}
+	if profile.Parallelism <= 0 {
+		profile.Parallelism = 1
+	}
+	if profile.Confirm == "" {
+		profile.Confirm = ConfirmAll
+	}
+	if profile.IpDetectURL == "" {
+		profile.IpDetectURL = DefaultIpDetectURL
+	}
+	return profile
+}
+
+func ListProfiles() []string {
+	names := []string{}
+	for name := range GlobalConfig.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func UseProfile(name string) error {
+	return update(func() error {
+		if GlobalConfig.Profiles == nil {
+			GlobalConfig.Profiles = map[string]*ProfileConfig{}
+		}
+		if _, ok := GlobalConfig.Profiles[name]; !ok {
+			GlobalConfig.Profiles[name] = &ProfileConfig{}
+		}
+		GlobalConfig.CurrentProfile = name
+		return nil
+	})
+}
+
+// DefaultRegion 返回账户的默认地域，环境变量LHBIN_REGION优先
+func DefaultRegion(account *AccountConfig) string {
+	if region := os.Getenv(RegionEnv); region != "" {
+		return region
+	}
+	if account != nil {
+		return account.Region
+	}
+	return ""
+}
+
+// profileAccount 查找指定配置使用的账户，account为空时为该配置的默认账户，配置也没有设置默认账户时为第一个账户。
+// 和FindAcount不同，不会使用当前生效配置的默认账户
+func profileAccount(profile *ProfileConfig, account string) (*AccountConfig, error) {
+	if account == "" {
+		account = profile.Account
+	}
+	for _, acc := range GlobalConfig.Accounts {
+		if acc.Driver == QQCloud && (account == "" || acc.Account == account) {
+			return acc, nil
+		}
+	}
+	return nil, errors.New("未找到对应的账户")
+}
+
+// GetProfileValue 读取指定配置中的配置项。region是账户级别的配置，读取的是该配置默认账户的地域
+func GetProfileValue(name, key string) (string, error) {
+	profile := GetProfile(name)
+	switch key {
+	case "account":
+		return profile.Account, nil
+	case "region":
+		account, err := profileAccount(profile, "")
+		if err != nil {
+			return "", err
+		}
+		return account.Region, nil
+	case "output":
+		return string(profile.Output), nil
+	case "parallelism":
+		return strconv.Itoa(profile.Parallelism), nil
+	case "confirm":
+		return string(profile.Confirm), nil
+	case "ip-detect-url":
+		return profile.IpDetectURL, nil
+	}
+	return "", fmt.Errorf("不支持的配置项%s，可选值为%s", key, strings.Join(ProfileKeys, "、"))
+}
+
+// SetProfileValue 修改指定配置中的配置项。修改region时，account为空则修改该配置默认账户的地域
+func SetProfileValue(name, account, key, value string) error {
+	return update(func() error {
+		return setProfileValue(name, account, key, value)
+	})
+}
+
+func setProfileValue(name, account, key, value string) error {
+	if GlobalConfig.Profiles == nil {
+		GlobalConfig.Profiles = map[string]*ProfileConfig{}
+	}
+	profile, ok := GlobalConfig.Profiles[name]
+	if !ok {
+		profile = &ProfileConfig{}
+		GlobalConfig.Profiles[name] = profile
+	}
+
+	switch key {
+	case "account":
+		if value != "" {
+			if _, err := FindAcount(QQCloud, value); err != nil {
+				return err
+			}
+		}
+		profile.Account = value
+	case "region":
+		acc, err := profileAccount(profile, account)
+		if err != nil {
+			return err
+		}
+		acc.Region = value
+	case "output":
+		output := OutputFormat(value)
+		if output != TableOutput && output != JsonOutput {
+			return fmt.Errorf("输出格式只能为%s或者%s", TableOutput, JsonOutput)
+		}
+		profile.Output = output
+	case "parallelism":
+		parallelism, err := strconv.Atoi(value)
+		if err != nil || parallelism <= 0 {
+			return fmt.Errorf("并发数必须为正整数")
+		}
+		profile.Parallelism = parallelism
+	case "confirm":
+		confirm := ConfirmPolicy(value)
+		if confirm != ConfirmAll && confirm != ConfirmDanger && confirm != ConfirmNone {
+			return fmt.Errorf("确认策略只能为%s、%s或者%s", ConfirmAll, ConfirmDanger, ConfirmNone)
+		}
+		profile.Confirm = confirm
+	case "ip-detect-url":
+		if value != "" && !strings.HasPrefix(value, "http://") && !strings.HasPrefix(value, "https://") {
+			return fmt.Errorf("查询公网IP的地址必须以http://或者https://开头")
+		}
+		profile.IpDetectURL = value
+	default:
+		return fmt.Errorf("不支持的配置项%s，可选值为%s", key, strings.Join(ProfileKeys, "、"))
+	}
+
+	return nil
+}
@@ -0,0 +1,67 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+
+	"gopkg.in/yaml.v2"
+)
+
+// ScheduleJob daemon中的一个定时任务
+type ScheduleJob struct {
+	Name      string            `yaml:"name"`
+	Cron      string            `yaml:"cron"`                // 标准的5段cron表达式，也支持@daily等写法
+	Action    string            `yaml:"action"`              // 执行的操作，例如snapshot-rotate
+	Account   string            `yaml:"account,omitempty"`   // 不填则为当前配置的默认账户
+	Region    string            `yaml:"region,omitempty"`    // 不填则为账户的默认地域，all表示所有地域
+	Instances []string          `yaml:"instances,omitempty"` // 实例ID列表，不填则为地域下的所有实例
+	Filter    string            `yaml:"filter,omitempty"`    // 实例过滤条件，格式和firewall sync的to-filter相同
+	Params    map[string]string `yaml:"params,omitempty"`    // 操作的参数
+}
+
+type Schedule struct {
+	Jobs []*ScheduleJob `yaml:"jobs"`
+}
+
+// DefaultSchedulePath 返回默认的定时任务文件路径
+func DefaultSchedulePath() (string, error) {
+	dir, err := ConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "schedule.yaml"), nil
+}
+
+// LoadSchedule 读取定时任务文件，并检查任务名称是否为空或者重复
+func LoadSchedule(path string) (*Schedule, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	schedule := &Schedule{}
+	if err := yaml.Unmarshal(data, schedule); err != nil {
+		return nil, fmt.Errorf("定时任务文件%s格式错误:%s", path, err.Error())
+	}
+
+	names := map[string]bool{}
+	for index, job := range schedule.Jobs {
+		if job == nil || job.Name == "" {
+			return nil, fmt.Errorf("第%d个任务的名称不能为空", index+1)
+		}
+		if names[job.Name] {
+			return nil, fmt.Errorf("任务名称%s重复", job.Name)
+		}
+		names[job.Name] = true
+
+		if job.Cron == "" {
+			return nil, fmt.Errorf("任务%s的cron表达式不能为空", job.Name)
+		}
+		if job.Action == "" {
+			return nil, fmt.Errorf("任务%s的操作不能为空", job.Name)
+		}
+	}
+
+	return schedule, nil
+}
@@ -0,0 +1,78 @@
+package config
+
+import (
+	"fmt"
+)
+
+// CurrentVersion 当前程序使用的配置文件版本，修改配置文件结构时需要增加版本号并添加对应的升级函数
+//
+//	0 最初的版本，只有accounts
+//	1 增加了version、profiles以及账户的默认地域
+const CurrentVersion int = 1
+
+// migrations[n] 将配置从版本n升级到版本n+1
+var migrations = map[int]func(c *Config) error{
+	0: func(c *Config) error {
+		// 新增的字段都是可选的，不需要转换
+		return nil
+	},
+}
+
+func migrate(c *Config) error {
+	if c.Version > CurrentVersion {
+		return fmt.Errorf("配置文件版本为%d，高于当前程序支持的版本%d，请升级lhbin", c.Version, CurrentVersion)
+	}
+
+	for c.Version < CurrentVersion {
+		migration, ok := migrations[c.Version]
+		if !ok {
+			return fmt.Errorf("不支持从版本%d升级", c.Version)
+		}
+		if err := migration(c); err != nil {
+			return err
+		}
+		c.Version++
+	}
+	return nil
+}
+
+// Validate 检查配置内容是否合法
+func (c *Config) Validate() error {
+	names := map[string]bool{}
+	for index, account := range c.Accounts {
+		if account == nil {
+			return fmt.Errorf("第%d个账户为空", index+1)
+		}
+		if account.Driver != QQCloud {
+			return fmt.Errorf("账户%s的驱动%s不受支持", account.Account, account.Driver)
+		}
+		if account.Account == "" {
+			return fmt.Errorf("第%d个账户的名称为空", index+1)
+		}
+		if account.AKID == "" || account.AKSecret == "" {
+			return fmt.Errorf("账户%s的密钥ID或者密钥Key为空", account.Account)
+		}
+		key := string(account.Driver) + "/" + account.Account
+		if names[key] {
+			return fmt.Errorf("账户%s重复", account.Account)
+		}
+		names[key] = true
+	}
+
+	for name, profile := range c.Profiles {
+		if profile == nil {
+			continue
+		}
+		if profile.Output != "" && profile.Output != TableOutput && profile.Output != JsonOutput {
+			return fmt.Errorf("配置%s的输出格式%s不受支持", name, profile.Output)
+		}
+		if profile.Confirm != "" && profile.Confirm != ConfirmAll && profile.Confirm != ConfirmDanger && profile.Confirm != ConfirmNone {
+			return fmt.Errorf("配置%s的确认策略%s不受支持", name, profile.Confirm)
+		}
+		if profile.Parallelism < 0 {
+			return fmt.Errorf("配置%s的并发数不能为负数", name)
+		}
+	}
+
+	return nil
+}
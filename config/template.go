@@ -0,0 +1,140 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"gopkg.in/yaml.v2"
+)
+
+// FirewallTemplateRule 和driver.FirewallRule的字段一一对应，config包不能依赖driver包，所以单独定义
+type FirewallTemplateRule struct {
+	Protocol    string `yaml:"protocol"`
+	Port        string `yaml:"port"`
+	CidrBlock   string `yaml:"cidr,omitempty"`
+	Action      string `yaml:"action,omitempty"`
+	Description string `yaml:"description,omitempty"`
+}
+
+type FirewallTemplate struct {
+	Name        string                  `yaml:"name"`
+	Description string                  `yaml:"description,omitempty"`
+	Rules       []*FirewallTemplateRule `yaml:"rules"`
+}
+
+type firewallTemplates struct {
+	Templates []*FirewallTemplate `yaml:"templates"`
+}
+
+func firewallTemplatesPath() (string, error) {
+	dir, err := ConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "firewall_templates.yaml"), nil
+}
+
+func readFirewallTemplates(path string) (*firewallTemplates, error) {
+	templates := &firewallTemplates{}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return templates, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(data, templates); err != nil {
+		return nil, fmt.Errorf("防火墙模板文件%s格式错误:%s", path, err.Error())
+	}
+	return templates, nil
+}
+
+func updateFirewallTemplates(modify func(templates *firewallTemplates) error) error {
+	path, err := firewallTemplatesPath()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	unlock, err := lockFile(path + ".lock")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	templates, err := readFirewallTemplates(path)
+	if err != nil {
+		return err
+	}
+
+	if err := modify(templates); err != nil {
+		return err
+	}
+
+	data, err := yaml.Marshal(templates)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data, 0600)
+}
+
+// ListFirewallTemplates 返回按名称排序的所有防火墙模板
+func ListFirewallTemplates() ([]*FirewallTemplate, error) {
+	path, err := firewallTemplatesPath()
+	if err != nil {
+		return nil, err
+	}
+	templates, err := readFirewallTemplates(path)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(templates.Templates, func(i, j int) bool {
+		return templates.Templates[i].Name < templates.Templates[j].Name
+	})
+	return templates.Templates, nil
+}
+
+func FindFirewallTemplate(name string) (*FirewallTemplate, error) {
+	templates, err := ListFirewallTemplates()
+	if err != nil {
+		return nil, err
+	}
+	for _, template := range templates {
+		if template.Name == name {
+			return template, nil
+		}
+	}
+	return nil, fmt.Errorf("防火墙模板%s不存在", name)
+}
+
+// SaveFirewallTemplate 保存防火墙模板，同名模板会被覆盖
+func SaveFirewallTemplate(newTemplate *FirewallTemplate) error {
+	return updateFirewallTemplates(func(templates *firewallTemplates) error {
+		for index, template := range templates.Templates {
+			if template.Name == newTemplate.Name {
+				templates.Templates[index] = newTemplate
+				return nil
+			}
+		}
+		templates.Templates = append(templates.Templates, newTemplate)
+		return nil
+	})
+}
+
+func DeleteFirewallTemplate(name string) error {
+	return updateFirewallTemplates(func(templates *firewallTemplates) error {
+		for index, template := range templates.Templates {
+			if template.Name == name {
+				templates.Templates = append(templates.Templates[0:index], templates.Templates[index+1:]...)
+				return nil
+			}
+		}
+		return fmt.Errorf("防火墙模板%s不存在", name)
+	})
+}
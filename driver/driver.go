@@ -7,6 +7,9 @@ import (
 )
 
 type Driver interface {
+	AccountIdentity() (*AccountIdentity, error)
+	CheckPermissions(region string) ([]*ActionPermission, error)
+
 	ListRegions() ([]*Region, error)
 	ListZones(region string) ([]*Zone, error)
 
@@ -16,26 +19,39 @@ type Driver interface {
 	StartInstances(region string, instanceIDs []string) error
 	RestartInstances(region string, instanceIDs []string) error
 	TerminateInstances(region string, instanceIDs []string) error
-	ResetInstances(region string, instanceIDs []string, BlueprintId string) error
+	ResetInstances(region string, instanceIDs []string, BlueprintId string, options *ResetOptions) error
+	ResetCompatibility(region, instanceID, blueprintID string) (*ResetCompatibility, error)
 	ResetPassword(region string, instanceIDs []string, username, password string) error
+	RunCommand(region string, instanceIDs []string, command *RemoteCommand) (string, error)
+	InvocationTasks(region, invocationID string) ([]*InvocationTask, error)
 
 	InstancesTrafficPackages(region string, instanceIDs []string) ([]*TrafficPackage, error)
 
-	ListSnapshots(region, instanceID string) ([]*SnapShot, error)
+	ListSnapshots(region string, filter *SnapshotFilter) ([]*SnapShot, error)
 	SnapshotInfo(region, snapshotID string) (*SnapShot, error)
 	DeleteSnapshots(region string, snapshotIDs []string) error
 	CreateSnapshot(region, instanceID, name string) (*SnapShot, error)
+	ResourceQuota(region string, name ResourceQuotaName) (*ResourceQuota, error)
 	ApplySnapshot(region, instanceID, snapshotID string) error
 
 	ListBlueprints(region string, platformType PlatformType, blueprintType BlueprintType) ([]*Blueprint, error)
 	BlueprintInfo(region, blueprintID string) (*Blueprint, error)
 	DeleteBlueprints(region string, blueprintIDs []string) error
 	CreateBlueprint(region, instanceId, name, desctiprtion string) (*Blueprint, error)
+	ShareBlueprint(region, blueprintID string, accountIDs []string) error
+	UnshareBlueprint(region, blueprintID string, accountIDs []string) error
+	BlueprintShares(region, blueprintID string) ([]*BlueprintShare, error)
+	CopyBlueprint(region, blueprintID string, destinationRegions []string) error
+	BlueprintUsage(region, blueprintID string) ([]*InstanceInfo, error)
+	ModifyBlueprintAttribute(region, blueprintID, name, description string) error
 
 	ListFirewallRules(region string, instanceID string) ([]*FirewallRule, error)
+	FirewallRulesTemplate(region string) ([]*FirewallRule, error)
 	AddFirewallRules(region string, instanceID string, roles []*FirewallRule) error
 	UpdateFirewallRules(region string, instanceID string, roles []*FirewallRule) error
 	DeleteFirewallRules(region string, instanceID string, roles []*FirewallRule) error
+	ModifyFirewallRuleDescription(region string, instanceID string, role *FirewallRule) error
+	EditFirewallRule(region string, instanceID string, oldRole *FirewallRule, newRole *FirewallRule) error
 
 	ListKeyPair(region string) ([]*KeyPair, error)
 	CreateKeyPair(region string, name string) (*KeyPair, error)
@@ -64,3 +80,10 @@ func stringer(str []*string) []string {
 
 	return strs
 }
+
+func stringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
@@ -0,0 +1,350 @@
+package driver
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// FirewallRuleDiff 当前规则和期望规则之间的差异
+type FirewallRuleDiff struct {
+	Add    []*FirewallRule // 需要新增的规则
+	Remove []*FirewallRule // 需要删除的规则
+	Keep   []*FirewallRule // 保持不变的规则
+}
+
+func (diff *FirewallRuleDiff) Empty() bool {
+	return len(diff.Add) == 0 && len(diff.Remove) == 0
+}
+
+// FirewallRuleKey 返回用于判断两条规则是否相同的键，描述不参与比较。规则会先被规范化，格式不同但含义相同的规则返回相同的键
+func FirewallRuleKey(rule *FirewallRule) string {
+	normalized := *rule
+	if err := NormalizeFirewallRule(&normalized); err != nil {
+		normalized = *rule
+	}
+
+	cidr := normalized.CidrBlock
+	if ipnet := parseCidr(cidr); ipnet != nil {
+		cidr = ipnet.String()
+	}
+	return strings.Join([]string{string(normalized.Protocol), normalized.Port, cidr, string(normalized.Action)}, "|")
+}
+
+// DiffFirewallRules 计算从当前规则变成期望规则需要新增和删除哪些规则
+func DiffFirewallRules(current, desired []*FirewallRule) *FirewallRuleDiff {
+	diff := &FirewallRuleDiff{
+		Add:    []*FirewallRule{},
+		Remove: []*FirewallRule{},
+		Keep:   []*FirewallRule{},
+	}
+
+	currentKeys := map[string]bool{}
+	for _, rule := range current {
+		currentKeys[FirewallRuleKey(rule)] = true
+	}
+
+	desiredKeys := map[string]bool{}
+	for _, rule := range desired {
+		key := FirewallRuleKey(rule)
+		if desiredKeys[key] {
+			continue
+		}
+		desiredKeys[key] = true
+
+		if currentKeys[key] {
+			diff.Keep = append(diff.Keep, rule)
+		} else {
+			diff.Add = append(diff.Add, rule)
+		}
+	}
+
+	for _, rule := range current {
+		if !desiredKeys[FirewallRuleKey(rule)] {
+			diff.Remove = append(diff.Remove, rule)
+		}
+	}
+
+	return diff
+}
+
+// 轻量服务器防火墙规则描述的最大长度
+const MaxFirewallRuleDescriptionLength = 64
+
+type FirewallRuleIssueLevel string
+
+const (
+	FirewallRuleError   FirewallRuleIssueLevel = "ERROR"
+	FirewallRuleWarning FirewallRuleIssueLevel = "WARNING"
+)
+
+// FirewallRuleIssue 规则列表中发现的问题，Index和Other为规则在列表中的下标
+type FirewallRuleIssue struct {
+	Level   FirewallRuleIssueLevel
+	Index   int
+	Other   int
+	Message string
+}
+
+type portRange struct {
+	from int
+	to   int
+}
+
+// NormalizeFirewallRule 校验单条规则并统一格式：协议和策略转为大写，端口去掉多余的空格和前导0，
+// 来源为空时补全为0.0.0.0/0，带主机位的网段转换为网络地址
+func NormalizeFirewallRule(rule *FirewallRule) error {
+
+	rule.Protocol = FirewallRuleProtocol(strings.ToUpper(strings.TrimSpace(string(rule.Protocol))))
+	switch rule.Protocol {
+	case TcpRuleProtocol, UdpRuleProtocol, IcmpRuleProtocol, AllPRulerotocol:
+	default:
+		return fmt.Errorf("不支持的协议%s，可选值为TCP、UDP、ICMP、ALL", rule.Protocol)
+	}
+
+	port := strings.ToUpper(strings.TrimSpace(rule.Port))
+	if rule.Protocol == IcmpRuleProtocol || rule.Protocol == AllPRulerotocol {
+		if port != "" && port != "ALL" {
+			return fmt.Errorf("协议为%s时端口只能为ALL", rule.Protocol)
+		}
+		rule.Port = "ALL"
+	} else {
+		ranges, err := parsePorts(port)
+		if err != nil {
+			return err
+		}
+		rule.Port = formatPorts(port, ranges)
+	}
+
+	cidr, err := normalizeCidr(rule.CidrBlock)
+	if err != nil {
+		return err
+	}
+	rule.CidrBlock = cidr
+
+	rule.Action = FirewallRuleAction(strings.ToUpper(strings.TrimSpace(string(rule.Action))))
+	if rule.Action == "" {
+		rule.Action = AcceptRuleAction
+	}
+	if rule.Action != AcceptRuleAction && rule.Action != DropAcRuletion {
+		return fmt.Errorf("不支持的策略%s，可选值为ACCEPT、DROP", rule.Action)
+	}
+
+	rule.Description = strings.TrimSpace(rule.Description)
+	if utf8.RuneCountInString(rule.Description) > MaxFirewallRuleDescriptionLength {
+		return fmt.Errorf("规则描述不能超过%d个字符", MaxFirewallRuleDescriptionLength)
+	}
+
+	return nil
+}
+
+func parsePorts(port string) ([]portRange, error) {
+	if port == "" {
+		return nil, errors.New("端口不能为空")
+	}
+	if port == "ALL" {
+		return []portRange{{1, 65535}}, nil
+	}
+
+	ranges := []portRange{}
+	for _, item := range strings.Split(port, ",") {
+		item = strings.TrimSpace(item)
+		bounds := strings.SplitN(item, "-", 2)
+		from, err := parsePort(bounds[0])
+		if err != nil {
+			return nil, err
+		}
+		to := from
+		if len(bounds) == 2 {
+			to, err = parsePort(bounds[1])
+			if err != nil {
+				return nil, err
+			}
+			if to < from {
+				return nil, fmt.Errorf("端口范围%s的起始端口大于结束端口", item)
+			}
+		}
+		ranges = append(ranges, portRange{from, to})
+	}
+	return ranges, nil
+}
+
+func parsePort(port string) (int, error) {
+	p, err := strconv.Atoi(strings.TrimSpace(port))
+	if err != nil || p < 1 || p > 65535 {
+		return 0, fmt.Errorf("端口%s无效，端口的范围是1-65535", port)
+	}
+	return p, nil
+}
+
+func formatPorts(port string, ranges []portRange) string {
+	if port == "ALL" {
+		return "ALL"
+	}
+	items := []string{}
+	for _, r := range ranges {
+		if r.from == r.to {
+			items = append(items, strconv.Itoa(r.from))
+		} else {
+			items = append(items, fmt.Sprintf("%d-%d", r.from, r.to))
+		}
+	}
+	return strings.Join(items, ",")
+}
+
+func normalizeCidr(cidr string) (string, error) {
+	cidr = strings.TrimSpace(cidr)
+	if cidr == "" {
+		return "0.0.0.0/0", nil
+	}
+
+	if !strings.Contains(cidr, "/") {
+		ip := net.ParseIP(cidr)
+		if ip == nil {
+			return "", fmt.Errorf("来源%s不是有效的IP地址", cidr)
+		}
+		return ip.String(), nil
+	}
+
+	_, ipnet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return "", fmt.Errorf("来源%s不是有效的网段", cidr)
+	}
+	return ipnet.String(), nil
+}
+
+// parseCidr 把单个IP也当作网段处理，方便比较
+func parseCidr(cidr string) *net.IPNet {
+	if !strings.Contains(cidr, "/") {
+		ip := net.ParseIP(cidr)
+		if ip == nil {
+			return nil
+		}
+		if ip.To4() != nil {
+			return &net.IPNet{IP: ip.To4(), Mask: net.CIDRMask(32, 32)}
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}
+	}
+	_, ipnet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil
+	}
+	return ipnet
+}
+
+func cidrCovers(a, b *net.IPNet) bool {
+	aOnes, aBits := a.Mask.Size()
+	bOnes, bBits := b.Mask.Size()
+	return aBits == bBits && aOnes <= bOnes && a.Contains(b.IP)
+}
+
+func cidrOverlaps(a, b *net.IPNet) bool {
+	_, aBits := a.Mask.Size()
+	_, bBits := b.Mask.Size()
+	return aBits == bBits && (a.Contains(b.IP) || b.Contains(a.IP))
+}
+
+func protocolCovers(a, b FirewallRuleProtocol) bool {
+	return a == AllPRulerotocol || a == b
+}
+
+func protocolOverlaps(a, b FirewallRuleProtocol) bool {
+	return a == AllPRulerotocol || b == AllPRulerotocol || a == b
+}
+
+func rulePorts(rule *FirewallRule) []portRange {
+	if rule.Protocol == IcmpRuleProtocol || rule.Protocol == AllPRulerotocol {
+		return []portRange{{1, 65535}}
+	}
+	ranges, _ := parsePorts(rule.Port)
+	return ranges
+}
+
+func portsCover(a, b []portRange) bool {
+	for _, rb := range b {
+		covered := false
+		for _, ra := range a {
+			if ra.from <= rb.from && rb.to <= ra.to {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			return false
+		}
+	}
+	return true
+}
+
+func portsOverlap(a, b []portRange) bool {
+	for _, ra := range a {
+		for _, rb := range b {
+			if ra.from <= rb.to && rb.from <= ra.to {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ValidateFirewallRules 规范化规则列表中的每一条规则，并检查重复、被遮蔽以及互相冲突的规则。
+// 规则按照列表中的顺序匹配，排在前面的规则优先。格式错误时返回error，其它问题通过返回的列表给出
+func ValidateFirewallRules(rules []*FirewallRule) ([]*FirewallRuleIssue, error) {
+	for index, rule := range rules {
+		if err := NormalizeFirewallRule(rule); err != nil {
+			return nil, fmt.Errorf("第%d条规则错误:%s", index+1, err.Error())
+		}
+	}
+
+	issues := []*FirewallRuleIssue{}
+	for j := 1; j < len(rules); j++ {
+		later := rules[j]
+		laterPorts := rulePorts(later)
+		laterCidr := parseCidr(later.CidrBlock)
+
+		for i := 0; i < j; i++ {
+			earlier := rules[i]
+
+			if FirewallRuleKey(earlier) == FirewallRuleKey(later) {
+				issues = append(issues, &FirewallRuleIssue{
+					Level:   FirewallRuleError,
+					Index:   j,
+					Other:   i,
+					Message: fmt.Sprintf("第%d条规则和第%d条规则重复", j+1, i+1),
+				})
+				break
+			}
+
+			earlierPorts := rulePorts(earlier)
+			earlierCidr := parseCidr(earlier.CidrBlock)
+
+			if protocolCovers(earlier.Protocol, later.Protocol) && portsCover(earlierPorts, laterPorts) && cidrCovers(earlierCidr, laterCidr) {
+				message := fmt.Sprintf("第%d条规则完全被第%d条规则覆盖，不会生效", j+1, i+1)
+				if earlier.Action != later.Action {
+					message = fmt.Sprintf("第%d条规则被第%d条规则遮蔽，两者策略相反，第%d条规则不会生效", j+1, i+1, j+1)
+				}
+				issues = append(issues, &FirewallRuleIssue{
+					Level:   FirewallRuleWarning,
+					Index:   j,
+					Other:   i,
+					Message: message,
+				})
+				break
+			}
+
+			if earlier.Action != later.Action && protocolOverlaps(earlier.Protocol, later.Protocol) && portsOverlap(earlierPorts, laterPorts) && cidrOverlaps(earlierCidr, laterCidr) {
+				issues = append(issues, &FirewallRuleIssue{
+					Level:   FirewallRuleWarning,
+					Index:   j,
+					Other:   i,
+					Message: fmt.Sprintf("第%d条规则和第%d条规则部分重叠且策略相反，重叠部分以第%d条规则为准", j+1, i+1, i+1),
+				})
+			}
+		}
+	}
+
+	return issues, nil
+}
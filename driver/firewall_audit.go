@@ -0,0 +1,109 @@
+package driver
+
+import (
+	"fmt"
+	"sort"
+)
+
+type FirewallRiskLevel string
+
+const (
+	FirewallRiskHigh   FirewallRiskLevel = "HIGH"
+	FirewallRiskMedium FirewallRiskLevel = "MEDIUM"
+	FirewallRiskLow    FirewallRiskLevel = "LOW"
+)
+
+// Rank 返回风险等级的大小，等级越高数值越大，未知的等级返回0
+func (level FirewallRiskLevel) Rank() int {
+	switch level {
+	case FirewallRiskHigh:
+		return 3
+	case FirewallRiskMedium:
+		return 2
+	case FirewallRiskLow:
+		return 1
+	}
+	return 0
+}
+
+// SensitivePorts 对公网开放时视为高风险的端口
+var SensitivePorts = map[int]string{
+	22:    "SSH",
+	3389:  "RDP",
+	3306:  "MySQL",
+	6379:  "Redis",
+	27017: "MongoDB",
+}
+
+// LargePortRangeSize 单条规则开放的端口数量超过此值时视为端口范围过大
+const LargePortRangeSize int = 100
+
+type FirewallRuleRisk struct {
+	Level   FirewallRiskLevel
+	Message string
+}
+
+// isPublicCidr 判断来源是否为所有地址
+func isPublicCidr(cidr string) bool {
+	ipnet := parseCidr(cidr)
+	if ipnet == nil {
+		return false
+	}
+	ones, _ := ipnet.Mask.Size()
+	return ones == 0
+}
+
+// AuditFirewallRule 检查单条规则存在的风险，返回的风险按等级从高到低排列
+func AuditFirewallRule(rule *FirewallRule) []*FirewallRuleRisk {
+	normalized := *rule
+	if err := NormalizeFirewallRule(&normalized); err != nil {
+		return []*FirewallRuleRisk{{Level: FirewallRiskMedium, Message: "规则格式无法识别:" + err.Error()}}
+	}
+
+	risks := []*FirewallRuleRisk{}
+	public := isPublicCidr(normalized.CidrBlock)
+
+	if normalized.Action == AcceptRuleAction {
+		switch normalized.Protocol {
+		case AllPRulerotocol:
+			if public {
+				risks = append(risks, &FirewallRuleRisk{Level: FirewallRiskHigh, Message: "所有协议和端口对公网开放"})
+			} else {
+				risks = append(risks, &FirewallRuleRisk{Level: FirewallRiskMedium, Message: fmt.Sprintf("所有协议和端口对%s开放", normalized.CidrBlock)})
+			}
+		case IcmpRuleProtocol:
+		default:
+			ranges := rulePorts(&normalized)
+			if public {
+				ports := []int{}
+				for port := range SensitivePorts {
+					ports = append(ports, port)
+				}
+				sort.Ints(ports)
+				for _, port := range ports {
+					if portsOverlap(ranges, []portRange{{port, port}}) {
+						risks = append(risks, &FirewallRuleRisk{Level: FirewallRiskHigh, Message: fmt.Sprintf("%s端口%d对公网开放", SensitivePorts[port], port)})
+					}
+				}
+			}
+
+			size := 0
+			for _, r := range ranges {
+				size += r.to - r.from + 1
+			}
+			if size > LargePortRangeSize {
+				level := FirewallRiskLow
+				if public {
+					level = FirewallRiskMedium
+				}
+				risks = append(risks, &FirewallRuleRisk{Level: level, Message: fmt.Sprintf("开放的端口范围过大(%d个端口)", size)})
+			}
+		}
+	}
+
+	if normalized.Description == "" {
+		risks = append(risks, &FirewallRuleRisk{Level: FirewallRiskLow, Message: "规则没有描述"})
+	}
+
+	return risks
+}
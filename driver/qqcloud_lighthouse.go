@@ -2,9 +2,13 @@ package driver
 
 import (
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common"
+	tcerr "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common/errors"
+	tchttp "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common/http"
 	"github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common/profile"
 	lighthouse "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/lighthouse/v20200324"
 )
@@ -82,23 +86,38 @@ func (driver *QQCloudLHDriver) ListInstances(region string) ([]*InstanceInfo, er
 	cpf.HttpProfile.Endpoint = "lighthouse.tencentcloudapi.com"
 	client, _ := lighthouse.NewClient(driver.credential, region, cpf)
 
-	request := lighthouse.NewDescribeInstancesRequest()
-	request.Limit = common.Int64Ptr(100)
-
-	response, err := client.DescribeInstances(request)
-	if err != nil {
-		return nil, err
-	}
-
+	// 分页查询所有实例，孤儿快照清理、镜像使用检查等操作依赖完整的实例列表，列表不完整时返回错误
 	instances := []*InstanceInfo{}
+	var limit int64 = 100
+	for offset := int64(0); ; offset += limit {
+		request := lighthouse.NewDescribeInstancesRequest()
+		request.Limit = common.Int64Ptr(limit)
+		request.Offset = common.Int64Ptr(offset)
+
+		response, err := client.DescribeInstances(request)
+		if err != nil {
+			return nil, err
+		}
 
-	for _, lhinstance := range response.Response.InstanceSet {
+		for _, lhinstance := range response.Response.InstanceSet {
+			instances = append(instances, lhRespInstaceToInstaceInfo(region, lhinstance))
+		}
 
-		instances = append(instances, lhRespInstaceToInstaceInfo(region, lhinstance))
+		if response.Response.TotalCount == nil {
+			if int64(len(response.Response.InstanceSet)) < limit {
+				return instances, nil
+			}
+			continue
+		}
+		total := *response.Response.TotalCount
+		if int64(len(instances)) >= total {
+			return instances, nil
+		}
+		if len(response.Response.InstanceSet) == 0 {
+			return nil, fmt.Errorf("区域[%s]下共有%d个实例，但是只查询到%d个", region, total, len(instances))
+		}
 	}
 
-	return instances, nil
-
 }
 
 func (driver *QQCloudLHDriver) InstanceInfo(region, instanceID string) (*InstanceInfo, error) {
@@ -138,6 +157,9 @@ func lhRespInstaceToInstaceInfo(region string, lhinstance *lighthouse.Instance)
 		PrivateIP:    *lhinstance.PrivateAddresses[0],
 		Bandwidth:    int(*lhinstance.InternetAccessible.InternetMaxBandwidthOut),
 		State:        InstanceState(*lhinstance.InstanceState),
+
+		LatestOperation:      stringValue(lhinstance.LatestOperation),
+		LatestOperationState: stringValue(lhinstance.LatestOperationState),
 	}
 
 	if len(lhinstance.PublicAddresses) > 0 {
@@ -227,7 +249,11 @@ func (driver *QQCloudLHDriver) ResetPassword(region string, instanceIDs []string
 	return err
 }
 
-func (driver *QQCloudLHDriver) ResetInstances(region string, instanceIDs []string, BlueprintId string) error {
+func (driver *QQCloudLHDriver) ResetInstances(region string, instanceIDs []string, BlueprintId string, options *ResetOptions) error {
+	if !options.empty() {
+		return driver.resetInstancesWithOptions(region, instanceIDs, BlueprintId, options)
+	}
+
 	cpf := profile.NewClientProfile()
 	cpf.HttpProfile.Endpoint = "lighthouse.tencentcloudapi.com"
 	client, _ := lighthouse.NewClient(driver.credential, region, cpf)
@@ -248,6 +274,194 @@ func (driver *QQCloudLHDriver) ResetInstances(region string, instanceIDs []strin
 	return err
 }
 
+type resetLoginConfiguration struct {
+	AutoGeneratePassword *string   `json:"AutoGeneratePassword,omitempty" name:"AutoGeneratePassword"`
+	Password             *string   `json:"Password,omitempty" name:"Password"`
+	KeyIds               []*string `json:"KeyIds,omitempty" name:"KeyIds"`
+}
+
+type containerEnv struct {
+	Key   *string `json:"Key,omitempty" name:"Key"`
+	Value *string `json:"Value,omitempty" name:"Value"`
+}
+
+type containerPublishPort struct {
+	HostPort      *int64  `json:"HostPort,omitempty" name:"HostPort"`
+	ContainerPort *int64  `json:"ContainerPort,omitempty" name:"ContainerPort"`
+	Protocol      *string `json:"Protocol,omitempty" name:"Protocol"`
+}
+
+type containerVolume struct {
+	ContainerPath *string `json:"ContainerPath,omitempty" name:"ContainerPath"`
+	HostPath      *string `json:"HostPath,omitempty" name:"HostPath"`
+}
+
+type containerConfiguration struct {
+	ContainerImage *string                 `json:"ContainerImage,omitempty" name:"ContainerImage"`
+	ContainerName  *string                 `json:"ContainerName,omitempty" name:"ContainerName"`
+	Envs           []*containerEnv         `json:"Envs,omitempty" name:"Envs"`
+	PublishPorts   []*containerPublishPort `json:"PublishPorts,omitempty" name:"PublishPorts"`
+	Volumes        []*containerVolume      `json:"Volumes,omitempty" name:"Volumes"`
+	Command        *string                 `json:"Command,omitempty" name:"Command"`
+}
+
+// resetInstanceRequest 当前SDK的ResetInstanceRequest不支持登录配置和容器配置，因此自己定义请求
+type resetInstanceRequest struct {
+	*tchttp.BaseRequest
+	InstanceId         *string                   `json:"InstanceId,omitempty" name:"InstanceId"`
+	BlueprintId        *string                   `json:"BlueprintId,omitempty" name:"BlueprintId"`
+	Containers         []*containerConfiguration `json:"Containers,omitempty" name:"Containers"`
+	LoginConfiguration *resetLoginConfiguration  `json:"LoginConfiguration,omitempty" name:"LoginConfiguration"`
+}
+
+func dockerContainerToConfiguration(container *DockerContainer) (*containerConfiguration, error) {
+	configuration := &containerConfiguration{
+		ContainerImage: common.StringPtr(container.Image),
+	}
+	if container.Name != "" {
+		configuration.ContainerName = common.StringPtr(container.Name)
+	}
+	if container.Command != "" {
+		configuration.Command = common.StringPtr(container.Command)
+	}
+
+	for key, value := range container.Envs {
+		configuration.Envs = append(configuration.Envs, &containerEnv{Key: common.StringPtr(key), Value: common.StringPtr(value)})
+	}
+
+	for _, port := range container.Ports {
+		protocol := "tcp"
+		if index := strings.Index(port, "/"); index >= 0 {
+			protocol = strings.ToLower(port[index+1:])
+			port = port[0:index]
+		}
+		ports := strings.SplitN(port, ":", 2)
+		if len(ports) != 2 {
+			return nil, fmt.Errorf("容器端口映射%s格式错误，应为 主机端口:容器端口[/协议]", port)
+		}
+		hostPort, err := strconv.ParseInt(ports[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("容器端口映射%s的主机端口无效", port)
+		}
+		containerPort, err := strconv.ParseInt(ports[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("容器端口映射%s的容器端口无效", port)
+		}
+		configuration.PublishPorts = append(configuration.PublishPorts, &containerPublishPort{
+			HostPort:      common.Int64Ptr(hostPort),
+			ContainerPort: common.Int64Ptr(containerPort),
+			Protocol:      common.StringPtr(protocol),
+		})
+	}
+
+	for _, volume := range container.Volumes {
+		paths := strings.SplitN(volume, ":", 2)
+		if len(paths) != 2 {
+			return nil, fmt.Errorf("容器挂载%s格式错误，应为 主机路径:容器路径", volume)
+		}
+		configuration.Volumes = append(configuration.Volumes, &containerVolume{
+			HostPath:      common.StringPtr(paths[0]),
+			ContainerPath: common.StringPtr(paths[1]),
+		})
+	}
+
+	return configuration, nil
+}
+
+func (driver *QQCloudLHDriver) resetInstancesWithOptions(region string, instanceIDs []string, BlueprintId string, options *ResetOptions) error {
+	containers := []*containerConfiguration{}
+	for _, container := range options.Containers {
+		configuration, err := dockerContainerToConfiguration(container)
+		if err != nil {
+			return err
+		}
+		containers = append(containers, configuration)
+	}
+
+	var loginConfiguration *resetLoginConfiguration
+	if options.Password != "" || len(options.KeyIds) > 0 {
+		loginConfiguration = &resetLoginConfiguration{AutoGeneratePassword: common.StringPtr("YES")}
+		if options.Password != "" {
+			loginConfiguration.AutoGeneratePassword = common.StringPtr("NO")
+			loginConfiguration.Password = common.StringPtr(options.Password)
+		}
+		if len(options.KeyIds) > 0 {
+			loginConfiguration.KeyIds = common.StringPtrs(options.KeyIds)
+		}
+	}
+
+	client := driver.lighthouseCommonClient(region)
+
+	var err error
+	for _, instanceID := range instanceIDs {
+		request := &resetInstanceRequest{BaseRequest: &tchttp.BaseRequest{}}
+		request.Init().WithApiInfo("lighthouse", "2020-03-24", "ResetInstance")
+		request.InstanceId = common.StringPtr(instanceID)
+		request.BlueprintId = common.StringPtr(BlueprintId)
+		request.Containers = containers
+		request.LoginConfiguration = loginConfiguration
+
+		err0 := client.Send(request, &emptyResponse{BaseResponse: &tchttp.BaseResponse{}})
+		if err0 != nil {
+			err = err0
+		}
+	}
+
+	return err
+}
+
+// ResetCompatibility 查询实例是否可以重置为指定镜像，除了接口返回的结果外，还会检查镜像要求的内存和系统盘大小
+func (driver *QQCloudLHDriver) ResetCompatibility(region, instanceID, blueprintID string) (*ResetCompatibility, error) {
+	instance, err := driver.InstanceInfo(region, instanceID)
+	if err != nil {
+		return nil, err
+	}
+
+	cpf := profile.NewClientProfile()
+	cpf.HttpProfile.Endpoint = "lighthouse.tencentcloudapi.com"
+	client, _ := lighthouse.NewClient(driver.credential, region, cpf)
+
+	request := lighthouse.NewDescribeResetInstanceBlueprintsRequest()
+	request.InstanceId = common.StringPtr(instanceID)
+	request.Filters = []*lighthouse.Filter{
+		{
+			Name:   common.StringPtr("blueprint-id"),
+			Values: common.StringPtrs([]string{blueprintID}),
+		},
+	}
+
+	response, err := client.DescribeResetInstanceBlueprints(request)
+	if err != nil {
+		return nil, err
+	}
+
+	compatibility := &ResetCompatibility{InstanceId: instanceID}
+	for _, resetBlueprint := range response.Response.ResetInstanceBlueprintSet {
+		if resetBlueprint.BlueprintInfo == nil || stringValue(resetBlueprint.BlueprintInfo.BlueprintId) != blueprintID {
+			continue
+		}
+		compatibility.Blueprint = lhRespBlueprintToBlueprintInfo(resetBlueprint.BlueprintInfo)
+		compatibility.Resettable = resetBlueprint.IsResettable != nil && *resetBlueprint.IsResettable
+		compatibility.Message = stringValue(resetBlueprint.NonResettableMessage)
+	}
+
+	if compatibility.Blueprint == nil {
+		compatibility.Message = fmt.Sprintf("镜像%s不在实例可以重置的镜像列表中", blueprintID)
+		return compatibility, nil
+	}
+
+	blueprint := compatibility.Blueprint
+	if blueprint.RequiredMemory > int64(instance.Memory) {
+		compatibility.Resettable = false
+		compatibility.Message = fmt.Sprintf("镜像要求至少%dGB内存，实例只有%dGB", blueprint.RequiredMemory, instance.Memory)
+	} else if blueprint.RequiredDiskSize > int64(instance.Disk) {
+		compatibility.Resettable = false
+		compatibility.Message = fmt.Sprintf("镜像要求至少%dGB系统盘，实例只有%dGB", blueprint.RequiredDiskSize, instance.Disk)
+	}
+
+	return compatibility, nil
+}
+
 func (driver *QQCloudLHDriver) InstancesTrafficPackages(region string, instanceIDs []string) ([]*TrafficPackage, error) {
 	cpf := profile.NewClientProfile()
 	cpf.HttpProfile.Endpoint = "lighthouse.tencentcloudapi.com"
@@ -276,36 +490,93 @@ func (driver *QQCloudLHDriver) InstancesTrafficPackages(region string, instanceI
 	return packages, nil
 }
 
-func (driver *QQCloudLHDriver) ListSnapshots(region, instanceID string) ([]*SnapShot, error) {
+// ListSnapshots 查询地域下符合条件的快照。没有指定实例时会逐个查询地域下现有实例的快照来确定快照所属的实例，
+// 找不到所属实例的快照InstanceId为空，通常是已经销毁的实例留下的
+func (driver *QQCloudLHDriver) ListSnapshots(region string, filter *SnapshotFilter) ([]*SnapShot, error) {
 	cpf := profile.NewClientProfile()
 	cpf.HttpProfile.Endpoint = "lighthouse.tencentcloudapi.com"
 	client, _ := lighthouse.NewClient(driver.credential, region, cpf)
 
-	request := lighthouse.NewDescribeSnapshotsRequest()
-	request.Limit = common.Int64Ptr(100)
+	if filter == nil {
+		filter = &SnapshotFilter{}
+	}
 
-	request.Filters = []*lighthouse.Filter{
-		{
-			Name:   common.StringPtr("instance-id"),
-			Values: common.StringPtrs([]string{instanceID}),
-		},
+	filters := []*lighthouse.Filter{}
+	if filter.InstanceId != "" {
+		filters = append(filters, &lighthouse.Filter{Name: common.StringPtr("instance-id"), Values: common.StringPtrs([]string{filter.InstanceId})})
+	}
+	if filter.DiskId != "" {
+		filters = append(filters, &lighthouse.Filter{Name: common.StringPtr("disk-id"), Values: common.StringPtrs([]string{filter.DiskId})})
+	}
+	if filter.Name != "" {
+		filters = append(filters, &lighthouse.Filter{Name: common.StringPtr("snapshot-name"), Values: common.StringPtrs([]string{filter.Name})})
 	}
 
-	response, err := client.DescribeSnapshots(request)
+	lhsnapshots, err := describeAllSnapshots(client, filters)
 	if err != nil {
 		return nil, err
 	}
 
 	snapShots := []*SnapShot{}
+	for _, lhsnapshot := range lhsnapshots {
+		snapshot := lhRespSnapshotToSnapshotInfo(region, lhsnapshot)
+		snapshot.InstanceId = filter.InstanceId
+		snapShots = append(snapShots, snapshot)
+	}
+
+	if filter.InstanceId != "" || len(snapShots) == 0 {
+		return snapShots, nil
+	}
+
+	instances, err := driver.ListInstances(region)
+	if err != nil {
+		return nil, err
+	}
+
+	owners := map[string]string{}
+	for _, instance := range instances {
+		instanceSnapshots, err := describeAllSnapshots(client, []*lighthouse.Filter{
+			{Name: common.StringPtr("instance-id"), Values: common.StringPtrs([]string{instance.ID})},
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, lhsnapshot := range instanceSnapshots {
+			owners[*lhsnapshot.SnapshotId] = instance.ID
+		}
+	}
 
-	for _, lhsnapshot := range response.Response.SnapshotSet {
-		snapShots = append(snapShots, lhRespSnapshotToSnapshotInfo(region, lhsnapshot))
+	for _, snapshot := range snapShots {
+		snapshot.InstanceId = owners[snapshot.SnapShot]
 	}
 
 	return snapShots, nil
 
 }
 
+func describeAllSnapshots(client *lighthouse.Client, filters []*lighthouse.Filter) ([]*lighthouse.Snapshot, error) {
+	lhsnapshots := []*lighthouse.Snapshot{}
+	var limit int64 = 100
+	for offset := int64(0); ; offset += limit {
+		request := lighthouse.NewDescribeSnapshotsRequest()
+		request.Limit = common.Int64Ptr(limit)
+		request.Offset = common.Int64Ptr(offset)
+		if len(filters) > 0 {
+			request.Filters = filters
+		}
+
+		response, err := client.DescribeSnapshots(request)
+		if err != nil {
+			return nil, err
+		}
+
+		lhsnapshots = append(lhsnapshots, response.Response.SnapshotSet...)
+		if int64(len(response.Response.SnapshotSet)) < limit || (response.Response.TotalCount != nil && int64(len(lhsnapshots)) >= *response.Response.TotalCount) {
+			return lhsnapshots, nil
+		}
+	}
+}
+
 func (driver *QQCloudLHDriver) SnapshotInfo(region, snapshotID string) (*SnapShot, error) {
 	cpf := profile.NewClientProfile()
 	cpf.HttpProfile.Endpoint = "lighthouse.tencentcloudapi.com"
@@ -329,10 +600,18 @@ func (driver *QQCloudLHDriver) SnapshotInfo(region, snapshotID string) (*SnapSho
 
 func lhRespSnapshotToSnapshotInfo(region string, lhsnapshot *lighthouse.Snapshot) *SnapShot {
 	snapshot := &SnapShot{
-		SnapShot: *lhsnapshot.SnapshotId,
-		Name:     *lhsnapshot.SnapshotName,
-		Percent:  int(*lhsnapshot.Percent),
-		State:    SnapShotState(*lhsnapshot.SnapshotState),
+		SnapShot:             *lhsnapshot.SnapshotId,
+		Name:                 *lhsnapshot.SnapshotName,
+		Percent:              int(*lhsnapshot.Percent),
+		State:                SnapShotState(*lhsnapshot.SnapshotState),
+		DiskId:               stringValue(lhsnapshot.DiskId),
+		DiskUsage:            stringValue(lhsnapshot.DiskUsage),
+		LatestOperation:      stringValue(lhsnapshot.LatestOperation),
+		LatestOperationState: stringValue(lhsnapshot.LatestOperationState),
+	}
+
+	if lhsnapshot.DiskSize != nil {
+		snapshot.DiskSize = *lhsnapshot.DiskSize
 	}
 
 	if lhsnapshot.CreatedTime != nil {
@@ -374,6 +653,33 @@ func (driver *QQCloudLHDriver) CreateSnapshot(region, instanceID, name string) (
 	}, nil
 }
 
+func (driver *QQCloudLHDriver) ResourceQuota(region string, name ResourceQuotaName) (*ResourceQuota, error) {
+	cpf := profile.NewClientProfile()
+	cpf.HttpProfile.Endpoint = "lighthouse.tencentcloudapi.com"
+	client, _ := lighthouse.NewClient(driver.credential, region, cpf)
+
+	request := lighthouse.NewDescribeGeneralResourceQuotasRequest()
+
+	request.ResourceNames = common.StringPtrs([]string{string(name)})
+
+	response, err := client.DescribeGeneralResourceQuotas(request)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, quota := range response.Response.GeneralResourceQuotaSet {
+		if stringValue(quota.ResourceName) == string(name) {
+			return &ResourceQuota{
+				Name:      name,
+				Available: int(*quota.ResourceQuotaAvailable),
+				Total:     int(*quota.ResourceQuotaTotal),
+			}, nil
+		}
+	}
+
+	return nil, fmt.Errorf("区域[%s]下没有查询到%s的配额", region, name)
+}
+
 func (driver *QQCloudLHDriver) ApplySnapshot(region, instanceID, snapshotID string) error {
 	cpf := profile.NewClientProfile()
 	cpf.HttpProfile.Endpoint = "lighthouse.tencentcloudapi.com"
@@ -441,14 +747,118 @@ func (driver *QQCloudLHDriver) BlueprintInfo(region, blueprintID string) (*Bluep
 	}
 
 	if len(response.Response.BlueprintSet) > 0 {
-		return lhRespBlueprintToBlueprintInfo(response.Response.BlueprintSet[0]), nil
+		blueprint := lhRespBlueprintToBlueprintInfo(response.Response.BlueprintSet[0])
+		if blueprint.ImageId != "" {
+			// 镜像大小只能从对应的CVM镜像查询，查询失败不影响镜像的其它信息
+			blueprint.ImageSize, _ = driver.imageSize(region, blueprint.ImageId)
+		}
+		return blueprint, nil
 	}
 
 	return nil, fmt.Errorf("区域[%s]下不存在镜像[%s]", region, blueprintID)
 }
 
+type describeImagesRequest struct {
+	*tchttp.BaseRequest
+	ImageIds []*string `json:"ImageIds,omitempty" name:"ImageIds"`
+}
+
+type describeImagesResponse struct {
+	*tchttp.BaseResponse
+	Response *struct {
+		ImageSet []*struct {
+			ImageId   *string `json:"ImageId,omitempty"`
+			ImageSize *int64  `json:"ImageSize,omitempty"`
+		} `json:"ImageSet,omitempty"`
+		RequestId *string `json:"RequestId,omitempty"`
+	} `json:"Response"`
+}
+
+func (driver *QQCloudLHDriver) imageSize(region, imageId string) (int64, error) {
+	cpf := profile.NewClientProfile()
+	cpf.HttpProfile.Endpoint = "cvm.tencentcloudapi.com"
+	client := common.NewCommonClient(driver.credential, region, cpf)
+
+	request := &describeImagesRequest{BaseRequest: &tchttp.BaseRequest{}}
+	request.Init().WithApiInfo("cvm", "2017-03-12", "DescribeImages")
+	request.ImageIds = common.StringPtrs([]string{imageId})
+	response := &describeImagesResponse{BaseResponse: &tchttp.BaseResponse{}}
+
+	if err := client.Send(request, response); err != nil {
+		return 0, err
+	}
+
+	for _, image := range response.Response.ImageSet {
+		if stringValue(image.ImageId) == imageId && image.ImageSize != nil {
+			return *image.ImageSize, nil
+		}
+	}
+	return 0, fmt.Errorf("区域[%s]下不存在CVM镜像[%s]", region, imageId)
+}
+
+// BlueprintUsage 返回地域下使用指定镜像创建或者重装的实例
+func (driver *QQCloudLHDriver) BlueprintUsage(region, blueprintID string) ([]*InstanceInfo, error) {
+	instances, err := driver.ListInstances(region)
+	if err != nil {
+		return nil, err
+	}
+
+	cpf := profile.NewClientProfile()
+	cpf.HttpProfile.Endpoint = "lighthouse.tencentcloudapi.com"
+	client, _ := lighthouse.NewClient(driver.credential, region, cpf)
+
+	instanceMap := map[string]*InstanceInfo{}
+	for _, instance := range instances {
+		instanceMap[instance.ID] = instance
+	}
+
+	used := []*InstanceInfo{}
+	// ListInstances返回地域下的全部实例，DescribeBlueprintInstances每次最多查询100个实例，需要分批查询
+	for start := 0; start < len(instances); start += 100 {
+		end := start + 100
+		if end > len(instances) {
+			end = len(instances)
+		}
+
+		instanceIds := []string{}
+		for _, instance := range instances[start:end] {
+			instanceIds = append(instanceIds, instance.ID)
+		}
+
+		request := lighthouse.NewDescribeBlueprintInstancesRequest()
+		request.InstanceIds = common.StringPtrs(instanceIds)
+
+		response, err := client.DescribeBlueprintInstances(request)
+		if err != nil {
+			return nil, err
+		}
+
+		// 有实例没有返回镜像信息时无法确认镜像是否在使用，返回错误，避免清理镜像时误删
+		reported := map[string]bool{}
+		for _, blueprintInstance := range response.Response.BlueprintInstanceSet {
+			if blueprintInstance.Blueprint == nil {
+				continue
+			}
+			reported[stringValue(blueprintInstance.InstanceId)] = true
+			if stringValue(blueprintInstance.Blueprint.BlueprintId) != blueprintID {
+				continue
+			}
+			if instance, ok := instanceMap[stringValue(blueprintInstance.InstanceId)]; ok {
+				used = append(used, instance)
+			}
+		}
+		for _, instanceId := range instanceIds {
+			if !reported[instanceId] {
+				return nil, fmt.Errorf("无法查询实例%s使用的镜像", instanceId)
+			}
+		}
+	}
+
+	return used, nil
+}
+
 func lhRespBlueprintToBlueprintInfo(lhblueprint *lighthouse.Blueprint) *Blueprint {
-	return &Blueprint{
+	blueprint := &Blueprint{
 		Blueprint:        *lhblueprint.BlueprintId,
 		Name:             *lhblueprint.BlueprintName,
 		Description:      *lhblueprint.Description,
@@ -456,7 +866,38 @@ func lhRespBlueprintToBlueprintInfo(lhblueprint *lighthouse.Blueprint) *Blueprin
 		RequiredDiskSize: *lhblueprint.RequiredSystemDiskSize,
 		RequiredMemory:   *lhblueprint.RequiredMemorySize,
 		State:            BlueprintState(*lhblueprint.BlueprintState),
+		Type:             BlueprintType(stringValue(lhblueprint.BlueprintType)),
+		ImageId:          stringValue(lhblueprint.ImageId),
+		Platform:         stringValue(lhblueprint.Platform),
+		PlatformType:     PlatformType(stringValue(lhblueprint.PlatformType)),
+	}
+	if lhblueprint.CreatedTime != nil {
+		blueprint.CreatedTime, _ = time.Parse(time.RFC3339, *lhblueprint.CreatedTime)
 	}
+	if lhblueprint.SupportAutomationTools != nil {
+		blueprint.SupportTat = *lhblueprint.SupportAutomationTools
+	}
+	return blueprint
+}
+
+// ModifyBlueprintAttribute 修改镜像的名称和描述，为空的字段保持不变
+func (driver *QQCloudLHDriver) ModifyBlueprintAttribute(region, blueprintID, name, description string) error {
+	cpf := profile.NewClientProfile()
+	cpf.HttpProfile.Endpoint = "lighthouse.tencentcloudapi.com"
+	client, _ := lighthouse.NewClient(driver.credential, region, cpf)
+
+	request := lighthouse.NewModifyBlueprintAttributeRequest()
+
+	request.BlueprintId = common.StringPtr(blueprintID)
+	if name != "" {
+		request.BlueprintName = common.StringPtr(name)
+	}
+	if description != "" {
+		request.Description = common.StringPtr(description)
+	}
+
+	_, err := client.ModifyBlueprintAttribute(request)
+	return err
 }
 
 func (driver *QQCloudLHDriver) DeleteBlueprints(region string, blueprintIDs []string) error {
@@ -496,6 +937,110 @@ func (driver *QQCloudLHDriver) CreateBlueprint(region, instanceId, name, desctip
 	}, nil
 }
 
+// 当前使用的SDK版本中还没有镜像共享和同步相关的接口，这里参照SDK的格式定义请求并通过通用客户端调用
+
+type blueprintAccountsRequest struct {
+	*tchttp.BaseRequest
+	BlueprintId *string   `json:"BlueprintId,omitempty" name:"BlueprintId"`
+	AccountIds  []*string `json:"AccountIds,omitempty" name:"AccountIds"`
+}
+
+type syncBlueprintRequest struct {
+	*tchttp.BaseRequest
+	BlueprintId        *string   `json:"BlueprintId,omitempty" name:"BlueprintId"`
+	DestinationRegions []*string `json:"DestinationRegions,omitempty" name:"DestinationRegions"`
+}
+
+type emptyResponse struct {
+	*tchttp.BaseResponse
+	Response *struct {
+		RequestId *string `json:"RequestId,omitempty"`
+	} `json:"Response"`
+}
+
+type describeImageSharePermissionRequest struct {
+	*tchttp.BaseRequest
+	ImageId *string `json:"ImageId,omitempty" name:"ImageId"`
+}
+
+type describeImageSharePermissionResponse struct {
+	*tchttp.BaseResponse
+	Response *struct {
+		SharePermissionSet []*struct {
+			CreatedTime *string `json:"CreatedTime,omitempty"`
+			AccountId   *string `json:"AccountId,omitempty"`
+		} `json:"SharePermissionSet,omitempty"`
+		RequestId *string `json:"RequestId,omitempty"`
+	} `json:"Response"`
+}
+
+func (driver *QQCloudLHDriver) lighthouseCommonClient(region string) *common.Client {
+	cpf := profile.NewClientProfile()
+	cpf.HttpProfile.Endpoint = "lighthouse.tencentcloudapi.com"
+	return common.NewCommonClient(driver.credential, region, cpf)
+}
+
+func (driver *QQCloudLHDriver) ShareBlueprint(region, blueprintID string, accountIDs []string) error {
+	request := &blueprintAccountsRequest{BaseRequest: &tchttp.BaseRequest{}}
+	request.Init().WithApiInfo("lighthouse", "2020-03-24", "ShareBlueprintAcrossAccounts")
+	request.BlueprintId = common.StringPtr(blueprintID)
+	request.AccountIds = common.StringPtrs(accountIDs)
+
+	return driver.lighthouseCommonClient(region).Send(request, &emptyResponse{BaseResponse: &tchttp.BaseResponse{}})
+}
+
+func (driver *QQCloudLHDriver) UnshareBlueprint(region, blueprintID string, accountIDs []string) error {
+	request := &blueprintAccountsRequest{BaseRequest: &tchttp.BaseRequest{}}
+	request.Init().WithApiInfo("lighthouse", "2020-03-24", "CancelShareBlueprintAcrossAccounts")
+	request.BlueprintId = common.StringPtr(blueprintID)
+	request.AccountIds = common.StringPtrs(accountIDs)
+
+	return driver.lighthouseCommonClient(region).Send(request, &emptyResponse{BaseResponse: &tchttp.BaseResponse{}})
+}
+
+// BlueprintShares 轻量服务器没有查询镜像共享对象的接口，镜像共享基于CVM镜像实现，所以通过CVM接口查询镜像对应的CVM镜像
+func (driver *QQCloudLHDriver) BlueprintShares(region, blueprintID string) ([]*BlueprintShare, error) {
+	blueprint, err := driver.BlueprintInfo(region, blueprintID)
+	if err != nil {
+		return nil, err
+	}
+	if blueprint.ImageId == "" {
+		return nil, fmt.Errorf("镜像[%s]没有对应的CVM镜像，无法查询共享信息", blueprintID)
+	}
+
+	cpf := profile.NewClientProfile()
+	cpf.HttpProfile.Endpoint = "cvm.tencentcloudapi.com"
+	client := common.NewCommonClient(driver.credential, region, cpf)
+
+	request := &describeImageSharePermissionRequest{BaseRequest: &tchttp.BaseRequest{}}
+	request.Init().WithApiInfo("cvm", "2017-03-12", "DescribeImageSharePermission")
+	request.ImageId = common.StringPtr(blueprint.ImageId)
+	response := &describeImageSharePermissionResponse{BaseResponse: &tchttp.BaseResponse{}}
+
+	if err := client.Send(request, response); err != nil {
+		return nil, err
+	}
+
+	shares := []*BlueprintShare{}
+	for _, permission := range response.Response.SharePermissionSet {
+		shares = append(shares, &BlueprintShare{
+			AccountId:   stringValue(permission.AccountId),
+			CreatedTime: stringValue(permission.CreatedTime),
+		})
+	}
+	return shares, nil
+}
+
+// CopyBlueprint 把镜像同步到其它地域，同步后的镜像ID和源镜像相同
+func (driver *QQCloudLHDriver) CopyBlueprint(region, blueprintID string, destinationRegions []string) error {
+	request := &syncBlueprintRequest{BaseRequest: &tchttp.BaseRequest{}}
+	request.Init().WithApiInfo("lighthouse", "2020-03-24", "SyncBlueprint")
+	request.BlueprintId = common.StringPtr(blueprintID)
+	request.DestinationRegions = common.StringPtrs(destinationRegions)
+
+	return driver.lighthouseCommonClient(region).Send(request, &emptyResponse{BaseResponse: &tchttp.BaseResponse{}})
+}
+
 func (driver *QQCloudLHDriver) ListFirewallRules(region string, instanceID string) ([]*FirewallRule, error) {
 	cpf := profile.NewClientProfile()
 	cpf.HttpProfile.Endpoint = "lighthouse.tencentcloudapi.com"
@@ -514,17 +1059,43 @@ func (driver *QQCloudLHDriver) ListFirewallRules(region string, instanceID strin
 	rules := []*FirewallRule{}
 
 	for _, lhrole := range response.Response.FirewallRuleSet {
-		rules = append(rules, &FirewallRule{
-			Protocol:    FirewallRuleProtocol(*lhrole.Protocol),
-			Port:        *lhrole.Port,
-			CidrBlock:   *lhrole.CidrBlock,
-			Action:      FirewallRuleAction(*lhrole.Action),
-			Description: *lhrole.FirewallRuleDescription,
-		})
+		rules = append(rules, lhRespFirewallRuleToFirewallRule(lhrole))
 	}
 
 	return rules, nil
 }
+
+func (driver *QQCloudLHDriver) FirewallRulesTemplate(region string) ([]*FirewallRule, error) {
+	cpf := profile.NewClientProfile()
+	cpf.HttpProfile.Endpoint = "lighthouse.tencentcloudapi.com"
+	client, _ := lighthouse.NewClient(driver.credential, region, cpf)
+
+	request := lighthouse.NewDescribeFirewallRulesTemplateRequest()
+
+	response, err := client.DescribeFirewallRulesTemplate(request)
+	if err != nil {
+		return nil, err
+	}
+
+	rules := []*FirewallRule{}
+
+	for _, lhrole := range response.Response.FirewallRuleSet {
+		rules = append(rules, lhRespFirewallRuleToFirewallRule(lhrole))
+	}
+
+	return rules, nil
+}
+
+func lhRespFirewallRuleToFirewallRule(lhrole *lighthouse.FirewallRuleInfo) *FirewallRule {
+	return &FirewallRule{
+		Protocol:    FirewallRuleProtocol(stringValue(lhrole.Protocol)),
+		Port:        stringValue(lhrole.Port),
+		CidrBlock:   stringValue(lhrole.CidrBlock),
+		Action:      FirewallRuleAction(stringValue(lhrole.Action)),
+		Description: stringValue(lhrole.FirewallRuleDescription),
+	}
+}
+
 func (driver *QQCloudLHDriver) AddFirewallRules(region string, instanceID string, roles []*FirewallRule) error {
 	cpf := profile.NewClientProfile()
 	cpf.HttpProfile.Endpoint = "lighthouse.tencentcloudapi.com"
@@ -592,6 +1163,74 @@ func (driver *QQCloudLHDriver) DeleteFirewallRules(region string, instanceID str
 	return err
 }
 
+func firewallRuleToLhFirewallRule(role *FirewallRule) *lighthouse.FirewallRule {
+	return &lighthouse.FirewallRule{
+		Protocol:                common.StringPtr(string(role.Protocol)),
+		Port:                    common.StringPtr(role.Port),
+		CidrBlock:               common.StringPtr(role.CidrBlock),
+		Action:                  common.StringPtr(string(role.Action)),
+		FirewallRuleDescription: common.StringPtr(role.Description),
+	}
+}
+
+func (driver *QQCloudLHDriver) ModifyFirewallRuleDescription(region string, instanceID string, role *FirewallRule) error {
+	cpf := profile.NewClientProfile()
+	cpf.HttpProfile.Endpoint = "lighthouse.tencentcloudapi.com"
+	client, _ := lighthouse.NewClient(driver.credential, region, cpf)
+
+	request := lighthouse.NewModifyFirewallRuleDescriptionRequest()
+
+	request.InstanceId = common.StringPtr(instanceID)
+	request.FirewallRule = firewallRuleToLhFirewallRule(role)
+
+	_, err := client.ModifyFirewallRuleDescription(request)
+	return err
+}
+
+// EditFirewallRule 修改单条规则。只修改描述时调用ModifyFirewallRuleDescription，
+// 否则在当前规则列表中原位替换后整体提交，并带上防火墙版本号，避免覆盖其它人同时做的修改
+func (driver *QQCloudLHDriver) EditFirewallRule(region string, instanceID string, oldRole *FirewallRule, newRole *FirewallRule) error {
+	if FirewallRuleKey(oldRole) == FirewallRuleKey(newRole) {
+		return driver.ModifyFirewallRuleDescription(region, instanceID, newRole)
+	}
+
+	cpf := profile.NewClientProfile()
+	cpf.HttpProfile.Endpoint = "lighthouse.tencentcloudapi.com"
+	client, _ := lighthouse.NewClient(driver.credential, region, cpf)
+
+	describeRequest := lighthouse.NewDescribeFirewallRulesRequest()
+	describeRequest.InstanceId = common.StringPtr(instanceID)
+	describeRequest.Limit = common.Int64Ptr(100)
+
+	response, err := client.DescribeFirewallRules(describeRequest)
+	if err != nil {
+		return err
+	}
+
+	oldKey := FirewallRuleKey(oldRole)
+	found := false
+	request := lighthouse.NewModifyFirewallRulesRequest()
+	request.InstanceId = common.StringPtr(instanceID)
+	request.FirewallVersion = response.Response.FirewallVersion
+	request.FirewallRules = []*lighthouse.FirewallRule{}
+
+	for _, lhrole := range response.Response.FirewallRuleSet {
+		role := lhRespFirewallRuleToFirewallRule(lhrole)
+		if !found && FirewallRuleKey(role) == oldKey {
+			role = newRole
+			found = true
+		}
+		request.FirewallRules = append(request.FirewallRules, firewallRuleToLhFirewallRule(role))
+	}
+
+	if !found {
+		return fmt.Errorf("实例%s中没有找到需要修改的规则", instanceID)
+	}
+
+	_, err = client.ModifyFirewallRules(request)
+	return err
+}
+
 func (driver *QQCloudLHDriver) ListKeyPair(region string) ([]*KeyPair, error) {
 
 	cpf := profile.NewClientProfile()
@@ -725,3 +1364,114 @@ func (driver *QQCloudLHDriver) UnBindKeyPairs(region string, keyids []string, in
 	_, err := client.DisassociateInstancesKeyPairs(request)
 	return err
 }
+
+type getCallerIdentityRequest struct {
+	*tchttp.BaseRequest
+}
+
+type getCallerIdentityResponse struct {
+	*tchttp.BaseResponse
+	Response *struct {
+		Arn         *string `json:"Arn,omitempty"`
+		AccountId   *string `json:"AccountId,omitempty"`
+		UserId      *string `json:"UserId,omitempty"`
+		PrincipalId *string `json:"PrincipalId,omitempty"`
+		Type        *string `json:"Type,omitempty"`
+		RequestId   *string `json:"RequestId,omitempty"`
+	} `json:"Response"`
+}
+
+func (driver *QQCloudLHDriver) AccountIdentity() (*AccountIdentity, error) {
+	cpf := profile.NewClientProfile()
+	cpf.HttpProfile.Endpoint = "sts.tencentcloudapi.com"
+	client := common.NewCommonClient(driver.credential, "ap-guangzhou", cpf)
+
+	request := &getCallerIdentityRequest{BaseRequest: &tchttp.BaseRequest{}}
+	request.Init().WithApiInfo("sts", "2018-08-13", "GetCallerIdentity")
+	response := &getCallerIdentityResponse{BaseResponse: &tchttp.BaseResponse{}}
+
+	err := client.Send(request, response)
+	if err != nil {
+		return nil, err
+	}
+
+	return &AccountIdentity{
+		AccountId:   stringValue(response.Response.AccountId),
+		UserId:      stringValue(response.Response.UserId),
+		PrincipalId: stringValue(response.Response.PrincipalId),
+		Type:        stringValue(response.Response.Type),
+		Arn:         stringValue(response.Response.Arn),
+	}, nil
+}
+
+// CheckPermissions 通过调用只读接口来判断当前密钥拥有哪些轻量服务器的操作权限，写操作无法在不产生副作用的情况下探测
+func (driver *QQCloudLHDriver) CheckPermissions(region string) ([]*ActionPermission, error) {
+	cpf := profile.NewClientProfile()
+	cpf.HttpProfile.Endpoint = "lighthouse.tencentcloudapi.com"
+	client, _ := lighthouse.NewClient(driver.credential, region, cpf)
+
+	probes := []struct {
+		action string
+		call   func() error
+	}{
+		{"DescribeRegions", func() error {
+			_, err := client.DescribeRegions(lighthouse.NewDescribeRegionsRequest())
+			return err
+		}},
+		{"DescribeZones", func() error {
+			_, err := client.DescribeZones(lighthouse.NewDescribeZonesRequest())
+			return err
+		}},
+		{"DescribeInstances", func() error {
+			_, err := client.DescribeInstances(lighthouse.NewDescribeInstancesRequest())
+			return err
+		}},
+		{"DescribeInstancesTrafficPackages", func() error {
+			_, err := client.DescribeInstancesTrafficPackages(lighthouse.NewDescribeInstancesTrafficPackagesRequest())
+			return err
+		}},
+		{"DescribeSnapshots", func() error {
+			_, err := client.DescribeSnapshots(lighthouse.NewDescribeSnapshotsRequest())
+			return err
+		}},
+		{"DescribeBlueprints", func() error {
+			_, err := client.DescribeBlueprints(lighthouse.NewDescribeBlueprintsRequest())
+			return err
+		}},
+		{"DescribeKeyPairs", func() error {
+			_, err := client.DescribeKeyPairs(lighthouse.NewDescribeKeyPairsRequest())
+			return err
+		}},
+		{"DescribeFirewallRulesTemplate", func() error {
+			_, err := client.DescribeFirewallRulesTemplate(lighthouse.NewDescribeFirewallRulesTemplateRequest())
+			return err
+		}},
+	}
+
+	permissions := []*ActionPermission{}
+	for _, probe := range probes {
+		err := probe.call()
+		if err == nil {
+			permissions = append(permissions, &ActionPermission{Action: probe.action, Allowed: true})
+			continue
+		}
+
+		sdkErr, ok := err.(*tcerr.TencentCloudSDKError)
+		if !ok {
+			return nil, err
+		}
+
+		switch {
+		case strings.HasPrefix(sdkErr.Code, "AuthFailure.UnauthorizedOperation") || strings.HasPrefix(sdkErr.Code, "UnauthorizedOperation"):
+			permissions = append(permissions, &ActionPermission{Action: probe.action, Allowed: false, Message: sdkErr.Message})
+		case strings.HasPrefix(sdkErr.Code, "AuthFailure") || strings.HasPrefix(sdkErr.Code, "ClientError"):
+			// 密钥本身无效或者网络不通，继续探测没有意义
+			return nil, err
+		default:
+			// 鉴权已经通过，只是请求本身有问题
+			permissions = append(permissions, &ActionPermission{Action: probe.action, Allowed: true, Message: sdkErr.Message})
+		}
+	}
+
+	return permissions, nil
+}
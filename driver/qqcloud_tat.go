@@ -0,0 +1,160 @@
+package driver
+
+import (
+	"encoding/base64"
+	"strings"
+
+	"github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common"
+	tchttp "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common/http"
+	"github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common/profile"
+)
+
+// tatEndpoint 自动化助手接口地址，测试中会替换为本地的http://地址
+var tatEndpoint = "tat.tencentcloudapi.com"
+
+const tatVersion = "2020-10-28"
+
+// 当前使用的SDK版本没有引入自动化助手的包，因此自己定义请求和响应
+type runCommandRequest struct {
+	*tchttp.BaseRequest
+	Content          *string   `json:"Content,omitempty" name:"Content"`
+	InstanceIds      []*string `json:"InstanceIds,omitempty" name:"InstanceIds"`
+	CommandType      *string   `json:"CommandType,omitempty" name:"CommandType"`
+	WorkingDirectory *string   `json:"WorkingDirectory,omitempty" name:"WorkingDirectory"`
+	Timeout          *int64    `json:"Timeout,omitempty" name:"Timeout"`
+	Username         *string   `json:"Username,omitempty" name:"Username"`
+	SaveCommand      *bool     `json:"SaveCommand,omitempty" name:"SaveCommand"`
+}
+
+type runCommandResponse struct {
+	*tchttp.BaseResponse
+	Response *struct {
+		CommandId    *string `json:"CommandId,omitempty"`
+		InvocationId *string `json:"InvocationId,omitempty"`
+		RequestId    *string `json:"RequestId,omitempty"`
+	} `json:"Response"`
+}
+
+type tatFilter struct {
+	Name   *string   `json:"Name,omitempty" name:"Name"`
+	Values []*string `json:"Values,omitempty" name:"Values"`
+}
+
+type describeInvocationTasksRequest struct {
+	*tchttp.BaseRequest
+	Filters    []*tatFilter `json:"Filters,omitempty" name:"Filters"`
+	Limit      *uint64      `json:"Limit,omitempty" name:"Limit"`
+	Offset     *uint64      `json:"Offset,omitempty" name:"Offset"`
+	HideOutput *bool        `json:"HideOutput,omitempty" name:"HideOutput"`
+}
+
+type describeInvocationTasksResponse struct {
+	*tchttp.BaseResponse
+	Response *struct {
+		TotalCount        *uint64 `json:"TotalCount,omitempty"`
+		InvocationTaskSet []*struct {
+			InvocationTaskId *string `json:"InvocationTaskId,omitempty"`
+			InstanceId       *string `json:"InstanceId,omitempty"`
+			TaskStatus       *string `json:"TaskStatus,omitempty"`
+			TaskResult       *struct {
+				ExitCode *int64  `json:"ExitCode,omitempty"`
+				Output   *string `json:"Output,omitempty"`
+				Dropped  *int64  `json:"Dropped,omitempty"`
+			} `json:"TaskResult,omitempty"`
+			ErrorInfo *string `json:"ErrorInfo,omitempty"`
+		} `json:"InvocationTaskSet,omitempty"`
+		RequestId *string `json:"RequestId,omitempty"`
+	} `json:"Response"`
+}
+
+func (driver *QQCloudLHDriver) tatClient(region string) *common.Client {
+	cpf := profile.NewClientProfile()
+	if strings.HasPrefix(tatEndpoint, "http://") {
+		cpf.HttpProfile.Scheme = "HTTP"
+	}
+	cpf.HttpProfile.Endpoint = strings.TrimPrefix(tatEndpoint, "http://")
+	return common.NewCommonClient(driver.credential, region, cpf)
+}
+
+// RunCommand 通过自动化助手在实例上执行命令，返回执行活动ID，命令在实例上异步执行
+func (driver *QQCloudLHDriver) RunCommand(region string, instanceIDs []string, command *RemoteCommand) (string, error) {
+	request := &runCommandRequest{BaseRequest: &tchttp.BaseRequest{}}
+	request.Init().WithApiInfo("tat", tatVersion, "RunCommand")
+	request.Content = common.StringPtr(base64.StdEncoding.EncodeToString([]byte(command.Content)))
+	request.InstanceIds = common.StringPtrs(instanceIDs)
+	request.SaveCommand = common.BoolPtr(false)
+	if command.CommandType != "" {
+		request.CommandType = common.StringPtr(string(command.CommandType))
+	}
+	if command.WorkingDirectory != "" {
+		request.WorkingDirectory = common.StringPtr(command.WorkingDirectory)
+	}
+	if command.Username != "" {
+		request.Username = common.StringPtr(command.Username)
+	}
+	if command.Timeout > 0 {
+		request.Timeout = common.Int64Ptr(command.Timeout)
+	}
+
+	response := &runCommandResponse{BaseResponse: &tchttp.BaseResponse{}}
+	if err := driver.tatClient(region).Send(request, response); err != nil {
+		return "", err
+	}
+
+	return stringValue(response.Response.InvocationId), nil
+}
+
+// InvocationTasks 查询执行活动在各个实例上的任务状态和输出
+func (driver *QQCloudLHDriver) InvocationTasks(region, invocationID string) ([]*InvocationTask, error) {
+	client := driver.tatClient(region)
+
+	tasks := []*InvocationTask{}
+	var offset uint64
+	for {
+		request := &describeInvocationTasksRequest{BaseRequest: &tchttp.BaseRequest{}}
+		request.Init().WithApiInfo("tat", tatVersion, "DescribeInvocationTasks")
+		request.Filters = []*tatFilter{
+			{
+				Name:   common.StringPtr("invocation-id"),
+				Values: common.StringPtrs([]string{invocationID}),
+			},
+		}
+		request.Limit = common.Uint64Ptr(100)
+		request.Offset = common.Uint64Ptr(offset)
+		request.HideOutput = common.BoolPtr(false)
+
+		response := &describeInvocationTasksResponse{BaseResponse: &tchttp.BaseResponse{}}
+		if err := client.Send(request, response); err != nil {
+			return nil, err
+		}
+
+		for _, tatTask := range response.Response.InvocationTaskSet {
+			task := &InvocationTask{
+				TaskId:     stringValue(tatTask.InvocationTaskId),
+				InstanceId: stringValue(tatTask.InstanceId),
+				Status:     InvocationTaskStatus(stringValue(tatTask.TaskStatus)),
+				ExitCode:   -1,
+				ErrorInfo:  stringValue(tatTask.ErrorInfo),
+			}
+			if result := tatTask.TaskResult; result != nil {
+				if result.ExitCode != nil {
+					task.ExitCode = *result.ExitCode
+				}
+				if result.Dropped != nil {
+					task.Dropped = *result.Dropped
+				}
+				if output, err := base64.StdEncoding.DecodeString(stringValue(result.Output)); err == nil {
+					task.Output = string(output)
+				}
+			}
+			tasks = append(tasks, task)
+		}
+
+		offset += uint64(len(response.Response.InvocationTaskSet))
+		if len(response.Response.InvocationTaskSet) == 0 || response.Response.TotalCount == nil || offset >= *response.Response.TotalCount {
+			break
+		}
+	}
+
+	return tasks, nil
+}
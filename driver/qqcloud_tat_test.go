@@ -0,0 +1,161 @@
+package driver
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common"
+)
+
+// fakeTat 模拟自动化助手的RunCommand和DescribeInvocationTasks接口
+type fakeTat struct {
+	mutex    sync.Mutex
+	tasks    []map[string]interface{}
+	run      map[string]interface{}
+	offsets  []int
+	pageSize int
+}
+
+func (fake *fakeTat) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	params := map[string]interface{}{}
+	json.Unmarshal(body, &params)
+
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+
+	var response map[string]interface{}
+	switch r.Header.Get("X-TC-Action") {
+	case "RunCommand":
+		fake.run = params
+		response = map[string]interface{}{"CommandId": "cmd-test", "InvocationId": "inv-test", "RequestId": "req"}
+	case "DescribeInvocationTasks":
+		offset := int(params["Offset"].(float64))
+		limit := int(params["Limit"].(float64))
+		if limit > fake.pageSize {
+			limit = fake.pageSize
+		}
+		fake.offsets = append(fake.offsets, offset)
+		end := offset + limit
+		if end > len(fake.tasks) {
+			end = len(fake.tasks)
+		}
+		page := []map[string]interface{}{}
+		if offset < end {
+			page = fake.tasks[offset:end]
+		}
+		response = map[string]interface{}{"TotalCount": len(fake.tasks), "InvocationTaskSet": page, "RequestId": "req"}
+	default:
+		response = map[string]interface{}{"Error": map[string]string{"Code": "InvalidAction", "Message": "unknown action"}, "RequestId": "req"}
+	}
+
+	data, _ := json.Marshal(map[string]interface{}{"Response": response})
+	w.Write(data)
+}
+
+func newFakeTatDriver(t *testing.T, fake *fakeTat) *QQCloudLHDriver {
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	endpoint := tatEndpoint
+	tatEndpoint = server.URL
+	t.Cleanup(func() { tatEndpoint = endpoint })
+	return &QQCloudLHDriver{credential: common.NewCredential("id", "key")}
+}
+
+func TestRunCommandEncodesContent(t *testing.T) {
+	fake := &fakeTat{}
+	driver := newFakeTatDriver(t, fake)
+
+	content := "#!/bin/bash\necho \"你好 $HOSTNAME\" && uptime\n"
+	invocationID, err := driver.RunCommand("ap-guangzhou", []string{"lhins-a", "lhins-b"}, &RemoteCommand{
+		Content:     content,
+		CommandType: ShellCommand,
+		Timeout:     30,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if invocationID != "inv-test" {
+		t.Fatalf("执行活动ID为%s，期望为inv-test", invocationID)
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(fake.run["Content"].(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(decoded) != content {
+		t.Fatalf("解码后的命令为%q，期望为%q", decoded, content)
+	}
+	if ids := fake.run["InstanceIds"].([]interface{}); len(ids) != 2 || ids[0] != "lhins-a" || ids[1] != "lhins-b" {
+		t.Fatalf("实例ID为%v", ids)
+	}
+	if fake.run["CommandType"] != "SHELL" || fake.run["Timeout"].(float64) != 30 {
+		t.Fatalf("请求参数为%v", fake.run)
+	}
+	if _, ok := fake.run["WorkingDirectory"]; ok {
+		t.Fatalf("没有设置的执行目录不应该出现在请求中")
+	}
+}
+
+func TestInvocationTasksPaging(t *testing.T) {
+	fake := &fakeTat{pageSize: 40}
+	for i := 0; i < 95; i++ {
+		result := map[string]interface{}{
+			"Output": base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("输出-%d\n", i))),
+		}
+		// 第一个任务还没有退出码，第二个任务输出被截断
+		switch i {
+		case 0:
+		case 1:
+			result["ExitCode"] = 3
+			result["Dropped"] = 1024
+		default:
+			result["ExitCode"] = 0
+		}
+		fake.tasks = append(fake.tasks, map[string]interface{}{
+			"InvocationTaskId": fmt.Sprintf("invt-%d", i),
+			"InstanceId":       fmt.Sprintf("lhins-%d", i),
+			"TaskStatus":       "SUCCESS",
+			"TaskResult":       result,
+		})
+	}
+	fake.tasks[0]["TaskStatus"] = "RUNNING"
+
+	driver := newFakeTatDriver(t, fake)
+	tasks, err := driver.InvocationTasks("ap-guangzhou", "inv-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(tasks) != 95 {
+		t.Fatalf("查询到%d个任务，期望为95个", len(tasks))
+	}
+	if fmt.Sprint(fake.offsets) != "[0 40 80]" {
+		t.Fatalf("分页查询的Offset为%v", fake.offsets)
+	}
+
+	for i, task := range tasks {
+		if task.TaskId != fmt.Sprintf("invt-%d", i) || task.InstanceId != fmt.Sprintf("lhins-%d", i) {
+			t.Fatalf("第%d个任务为%+v", i, task)
+		}
+		if task.Output != fmt.Sprintf("输出-%d\n", i) {
+			t.Fatalf("第%d个任务的输出为%q", i, task.Output)
+		}
+	}
+
+	if tasks[0].ExitCode != -1 || tasks[0].Status != TaskRunning || tasks[0].Status.Finished() {
+		t.Fatalf("没有退出码的任务为%+v", tasks[0])
+	}
+	if tasks[1].ExitCode != 3 || tasks[1].Dropped != 1024 {
+		t.Fatalf("输出被截断的任务为%+v", tasks[1])
+	}
+	if tasks[2].ExitCode != 0 || tasks[2].Dropped != 0 || !tasks[2].Status.Finished() {
+		t.Fatalf("执行成功的任务为%+v", tasks[2])
+	}
+}
@@ -51,6 +51,10 @@ type InstanceInfo struct {
 	State        InstanceState
 	CreatedTime  time.Time
 	ExpiredTime  time.Time
+	// 最新操作，例如AssociateInstancesKeyPairs
+	LatestOperation string
+	// 最新操作的状态，取值为SUCCESS、OPERATING、FAILED
+	LatestOperationState string
 }
 
 type TrafficPackage struct {
@@ -69,15 +73,48 @@ const (
 )
 
 type SnapShot struct {
-	SnapShot    string
-	Name        string
-	State       SnapShotState
-	Percent     int
-	CreatedTime time.Time
+	SnapShot             string
+	Name                 string
+	State                SnapShotState
+	Percent              int
+	CreatedTime          time.Time
+	InstanceId           string // 快照所属的实例，实例已经销毁时为空
+	DiskId               string
+	DiskUsage            string // 磁盘类型，例如SYSTEM_DISK
+	DiskSize             int64  // 磁盘大小，单位GB
+	LatestOperation      string // 最新操作，例如CreateInstanceSnapshot
+	LatestOperationState string // 最新操作的状态，取值为SUCCESS、OPERATING、FAILED
+}
+
+// SnapshotFilter 查询快照的过滤条件，为空的字段不参与过滤
+type SnapshotFilter struct {
+	InstanceId string
+	DiskId     string
+	Name       string
+}
+
+type ResourceQuotaName string
+
+const (
+	KeyPairQuota  ResourceQuotaName = "USER_KEY_PAIR"
+	InstanceQuota ResourceQuotaName = "INSTANCE"
+	SnapshotQuota ResourceQuotaName = "SNAPSHOT"
+)
+
+type ResourceQuota struct {
+	Name      ResourceQuotaName
+	Available int
+	Total     int
 }
 
 type BlueprintState string
 
+const (
+	BlueprintNormal   BlueprintState = "NORMAL"
+	BlueprintCreating BlueprintState = "CREATING"
+	BlueprintSyncing  BlueprintState = "SYNCING"
+)
+
 type PlatformType string
 
 const (
@@ -104,6 +141,52 @@ type Blueprint struct {
 	RequiredDiskSize int64
 	RequiredMemory   int64
 	State            BlueprintState
+	Type             BlueprintType
+	ImageId          string // 镜像对应的CVM镜像ID
+	Platform         string // 操作系统平台，例如CENTOS、UBUNTU、WINDOWS
+	PlatformType     PlatformType
+	CreatedTime      time.Time
+	SupportTat       bool  // 是否支持自动化助手
+	ImageSize        int64 // 镜像大小，单位GB，查询不到时为0
+}
+
+func (blueprint *Blueprint) IsWindows() bool {
+	return blueprint.PlatformType == WinPlatform
+}
+
+// ResetCompatibility 实例能否重置为指定的镜像
+type ResetCompatibility struct {
+	InstanceId string
+	Blueprint  *Blueprint // 镜像不在实例可重置的镜像列表中时为nil
+	Resettable bool
+	Message    string // 不能重置时的原因
+}
+
+// DockerContainer 重置实例后需要运行的容器
+type DockerContainer struct {
+	Image   string            `yaml:"image" json:"image"`
+	Name    string            `yaml:"name,omitempty" json:"name,omitempty"`
+	Envs    map[string]string `yaml:"envs,omitempty" json:"envs,omitempty"`
+	Ports   []string          `yaml:"ports,omitempty" json:"ports,omitempty"`     // 格式为 主机端口:容器端口[/协议]
+	Volumes []string          `yaml:"volumes,omitempty" json:"volumes,omitempty"` // 格式为 主机路径:容器路径
+	Command string            `yaml:"command,omitempty" json:"command,omitempty"`
+}
+
+// ResetOptions 重置实例时的可选配置，为空的字段使用默认值
+type ResetOptions struct {
+	Password   string
+	KeyIds     []string
+	Containers []*DockerContainer
+}
+
+func (options *ResetOptions) empty() bool {
+	return options == nil || (options.Password == "" && len(options.KeyIds) == 0 && len(options.Containers) == 0)
+}
+
+// BlueprintShare 镜像的一个共享对象
+type BlueprintShare struct {
+	AccountId   string
+	CreatedTime string
 }
 
 type FirewallRuleProtocol string
@@ -123,11 +206,11 @@ const (
 )
 
 type FirewallRule struct {
-	Protocol    FirewallRuleProtocol
-	Port        string
-	CidrBlock   string
-	Action      FirewallRuleAction
-	Description string
+	Protocol    FirewallRuleProtocol `yaml:"protocol" json:"protocol"`
+	Port        string               `yaml:"port" json:"port"`
+	CidrBlock   string               `yaml:"cidr,omitempty" json:"cidr,omitempty"`
+	Action      FirewallRuleAction   `yaml:"action,omitempty" json:"action,omitempty"`
+	Description string               `yaml:"description,omitempty" json:"description,omitempty"`
 }
 
 type KeyPair struct {
@@ -138,3 +221,70 @@ type KeyPair struct {
 	CreatedTime           time.Time
 	PrivateKey            string
 }
+
+type CommandType string
+
+const (
+	ShellCommand      CommandType = "SHELL"
+	PowerShellCommand CommandType = "POWERSHELL"
+)
+
+// RemoteCommand 通过自动化助手在实例上执行的命令
+type RemoteCommand struct {
+	Content          string
+	CommandType      CommandType
+	WorkingDirectory string
+	Username         string
+	Timeout          int64 // 命令超时时间，单位秒
+}
+
+type InvocationTaskStatus string
+
+const (
+	TaskPending        InvocationTaskStatus = "PENDING"
+	TaskDelivering     InvocationTaskStatus = "DELIVERING"
+	TaskDeliverDelayed InvocationTaskStatus = "DELIVER_DELAYED"
+	TaskDeliverFailed  InvocationTaskStatus = "DELIVER_FAILED"
+	TaskStartFailed    InvocationTaskStatus = "START_FAILED"
+	TaskRunning        InvocationTaskStatus = "RUNNING"
+	TaskSuccess        InvocationTaskStatus = "SUCCESS"
+	TaskFailed         InvocationTaskStatus = "FAILED"
+	TaskTimeout        InvocationTaskStatus = "TIMEOUT"
+	TaskCancelling     InvocationTaskStatus = "CANCELLING"
+	TaskCancelled      InvocationTaskStatus = "CANCELLED"
+	TaskTerminated     InvocationTaskStatus = "TERMINATED"
+)
+
+// Finished 任务是否已经结束，结束后状态不会再变化
+func (status InvocationTaskStatus) Finished() bool {
+	switch status {
+	case TaskPending, TaskDelivering, TaskDeliverDelayed, TaskRunning, TaskCancelling:
+		return false
+	}
+	return true
+}
+
+// InvocationTask 一次命令执行在单个实例上的任务
+type InvocationTask struct {
+	TaskId     string
+	InstanceId string
+	Status     InvocationTaskStatus
+	ExitCode   int64
+	Output     string // 已经解码的命令输出
+	Dropped    int64  // 输出超过限制被丢弃的字节数
+	ErrorInfo  string
+}
+
+type AccountIdentity struct {
+	AccountId   string // 主账号UIN
+	UserId      string // 调用者的UIN，子账号时和主账号不同
+	PrincipalId string
+	Type        string // 调用者类型，如RootAccount、CAMUser等
+	Arn         string
+}
+
+type ActionPermission struct {
+	Action  string
+	Allowed bool
+	Message string
+}
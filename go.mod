@@ -10,3 +10,10 @@ require (
 require gopkg.in/yaml.v2 v2.4.0
 
 require github.com/google/uuid v1.3.0
+
+require (
+	golang.org/x/crypto v0.14.0
+	golang.org/x/term v0.15.0
+)
+
+require golang.org/x/sys v0.15.0 // indirect